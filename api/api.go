@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -11,6 +12,7 @@ import (
 	"github.com/SkynetLabs/siacoin-promoter/promoter"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
+	"gitlab.com/NebulousLabs/errors"
 )
 
 type (
@@ -21,6 +23,14 @@ type (
 		staticLog      *logrus.Entry
 		staticRouter   *httprouter.Router
 		staticServer   *http.Server
+
+		staticAdminToken     string
+		staticClientCertAuth bool
+
+		staticRotateLimiter      *rateLimiter
+		staticAddressUserLimiter *rateLimiter
+		staticAddressIPLimiter   *rateLimiter
+		staticTrustProxyHeaders  bool
 	}
 
 	// errorWrap is a helper type for converting an `error` struct to JSON.
@@ -30,11 +40,21 @@ type (
 )
 
 // New creates a new API with the given logger and database.
-func New(log *logrus.Entry, p *promoter.Promoter, port int) (*API, error) {
+func New(log *logrus.Entry, p *promoter.Promoter, port int, opts Options) (*API, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.AddContext(err, "invalid api options")
+	}
+	tlsCfg, err := opts.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
 	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
 	if err != nil {
 		return nil, err
 	}
+	if tlsCfg != nil {
+		l = tls.NewListener(l, tlsCfg)
+	}
 	router := httprouter.New()
 	router.RedirectTrailingSlash = true
 	api := &API{
@@ -42,6 +62,15 @@ func New(log *logrus.Entry, p *promoter.Promoter, port int) (*API, error) {
 		staticListener: l,
 		staticLog:      log,
 		staticRouter:   router,
+
+		staticAdminToken:     opts.AdminToken,
+		staticClientCertAuth: opts.ClientCAFile != "",
+
+		staticRotateLimiter:      newRateLimiter(opts.RotateLimit),
+		staticAddressUserLimiter: newRateLimiter(opts.AddressUserLimit),
+		staticAddressIPLimiter:   newRateLimiter(opts.AddressIPLimit),
+		staticTrustProxyHeaders:  opts.TrustProxyHeaders,
+
 		staticServer: &http.Server{
 			Handler: router,
 
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/SkynetLabs/siacoin-promoter/promoter"
+	"github.com/julienschmidt/httprouter"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// actorToken is the actor logged for requests authenticated with the
+	// shared admin token.
+	actorToken = "token"
+
+	// actorCertPrefix is the prefix of the actor logged for requests
+	// authenticated with a client certificate. It is followed by the
+	// certificate's common name.
+	actorCertPrefix = "cert:"
+)
+
+var (
+	// errAdminDisabled is returned for admin routes if neither an admin
+	// token nor a client CA was configured.
+	errAdminDisabled = errors.New("admin authentication is not configured")
+
+	// errUnauthorized is returned for admin routes if the request didn't
+	// contain valid admin credentials.
+	errUnauthorized = errors.New("invalid or missing admin credentials")
+)
+
+type (
+	// Options contains the options for creating an API.
+	Options struct {
+		// AdminToken is the shared secret which needs to be sent as a
+		// bearer token to access admin routes.
+		AdminToken string
+
+		// TLSCertFile and TLSKeyFile are the paths of the certificate
+		// and key used for serving the API over TLS. If they are not
+		// set, the API is served over plain http.
+		TLSCertFile string
+		TLSKeyFile  string
+
+		// ClientCAFile is the path of a PEM encoded CA certificate.
+		// Clients presenting a certificate signed by that CA are
+		// granted access to admin routes. Requires TLS.
+		ClientCAFile string
+
+		// RotateLimit limits how often a user can rotate their
+		// address.
+		RotateLimit RateLimit
+
+		// AddressUserLimit and AddressIPLimit limit how often a single
+		// user or IP can request an address.
+		AddressUserLimit RateLimit
+		AddressIPLimit   RateLimit
+
+		// TrustProxyHeaders indicates whether the client IP used for
+		// rate limiting is taken from the X-Real-IP and
+		// X-Forwarded-For headers. Only enable it if the API is behind
+		// a reverse proxy which sets them.
+		TrustProxyHeaders bool
+	}
+
+	// statusRecorder is a http.ResponseWriter that remembers the status
+	// code of the response for logging.
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+// DefaultOptions returns the default options for an API.
+func DefaultOptions() Options {
+	return Options{
+		RotateLimit:      defaultRotateLimit,
+		AddressUserLimit: defaultAddressUserLimit,
+		AddressIPLimit:   defaultAddressIPLimit,
+	}
+}
+
+// Validate checks the options for consistency.
+func (o Options) Validate() error {
+	if err := o.RotateLimit.Validate(); err != nil {
+		return errors.AddContext(err, "invalid rotate limit")
+	}
+	if err := o.AddressUserLimit.Validate(); err != nil {
+		return errors.AddContext(err, "invalid address user limit")
+	}
+	if err := o.AddressIPLimit.Validate(); err != nil {
+		return errors.AddContext(err, "invalid address ip limit")
+	}
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		return errors.New("both or neither of the TLS cert and key need to be set")
+	}
+	if o.ClientCAFile != "" && o.TLSCertFile == "" {
+		return errors.New("client certificates require TLS to be enabled")
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS config for the options or nil if TLS is disabled.
+func (o Options) tlsConfig() (*tls.Config, error) {
+	if o.TLSCertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.TLSCertFile, o.TLSKeyFile)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to load TLS key pair")
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if o.ClientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := ioutil.ReadFile(o.ClientCAFile)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to read client CA file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("client CA file doesn't contain any certificates")
+	}
+	// Client certificates are optional since regular users don't have
+	// one. If one is sent, it has to be valid though.
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// authenticateAdmin checks the admin credentials of a request and returns the
+// actor the request was made by.
+func (api *API) authenticateAdmin(req *http.Request) (string, error) {
+	if api.staticAdminToken == "" && !api.staticClientCertAuth {
+		return "", errAdminDisabled
+	}
+	// Client certificates are verified during the TLS handshake. We only
+	// need to check that one was sent.
+	if api.staticClientCertAuth && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return actorCertPrefix + req.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+	}
+	auth := req.Header.Get("Authorization")
+	if api.staticAdminToken != "" && strings.HasPrefix(auth, "Bearer ") {
+		// Compare the hashes to not leak the token's length.
+		expected := sha256.Sum256([]byte(api.staticAdminToken))
+		actual := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+		if subtle.ConstantTimeCompare(expected[:], actual[:]) == 1 {
+			return actorToken, nil
+		}
+	}
+	return "", errUnauthorized
+}
+
+// adminHandler wraps a handler with admin authentication. Every request to an
+// admin route is logged together with the actor that made it. The actor is
+// attached to the request's context for the promoter's audit log.
+func (api *API) adminHandler(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		log := api.staticLog.WithFields(map[string]interface{}{
+			"method": req.Method,
+			"path":   req.URL.Path,
+			"remote": req.RemoteAddr,
+		})
+		actor, err := api.authenticateAdmin(req)
+		if errors.Contains(err, errAdminDisabled) {
+			log.Warn("Rejected admin request since admin authentication is disabled")
+			api.WriteError(w, err, http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Warn("Rejected unauthorized admin request")
+			api.WriteError(w, err, http.StatusUnauthorized)
+			return
+		}
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(sr, req.WithContext(promoter.ContextWithActor(req.Context(), actor)), ps)
+		log.WithFields(map[string]interface{}{
+			"actor":  actor,
+			"status": sr.status,
+		}).Info(fmt.Sprintf("Admin request %v %v", req.Method, req.URL.Path))
+	}
+}
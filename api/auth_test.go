@@ -0,0 +1,116 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/siacoin-promoter/promoter"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
+)
+
+// TestAdminHandler is a unit test for the admin authentication middleware.
+func TestAdminHandler(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	newAPI := func(token string, certAuth bool) *API {
+		return &API{
+			staticLog:            logrus.NewEntry(logger),
+			staticAdminToken:     token,
+			staticClientCertAuth: certAuth,
+		}
+	}
+
+	// The handler responds with the actor of the request.
+	var lastActor string
+	h := func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		lastActor = promoter.ActorFromContext(req.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}
+	serve := func(api *API, req *http.Request) int {
+		lastActor = ""
+		rec := httptest.NewRecorder()
+		api.adminHandler(h)(rec, req, nil)
+		return rec.Code
+	}
+	newRequest := func(auth string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/dead/foo", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return req
+	}
+
+	// Without any admin credentials configured, admin routes are disabled.
+	api := newAPI("", false)
+	if code := serve(api, newRequest("Bearer ")); code != http.StatusForbidden {
+		t.Fatal("wrong code", code)
+	}
+
+	// With a token, only requests with the right token are accepted.
+	api = newAPI("secret", false)
+	for _, auth := range []string{"", "secret", "Bearer wrong", "Bearer secretsecret"} {
+		if code := serve(api, newRequest(auth)); code != http.StatusUnauthorized {
+			t.Fatal("wrong code", auth, code)
+		}
+	}
+	if code := serve(api, newRequest("Bearer secret")); code != http.StatusNoContent {
+		t.Fatal("wrong code", code)
+	}
+	if lastActor != actorToken {
+		t.Fatal("wrong actor", lastActor)
+	}
+
+	// Verified client certificates are accepted if enabled.
+	certReq := newRequest("")
+	certReq.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "ops"}}}},
+	}
+	if code := serve(api, certReq); code != http.StatusUnauthorized {
+		t.Fatal("wrong code", code)
+	}
+	api = newAPI("", true)
+	if code := serve(api, certReq); code != http.StatusNoContent {
+		t.Fatal("wrong code", code)
+	}
+	if lastActor != actorCertPrefix+"ops" {
+		t.Fatal("wrong actor", lastActor)
+	}
+	if code := serve(api, newRequest("Bearer ")); code != http.StatusUnauthorized {
+		t.Fatal("wrong code", code)
+	}
+}
+
+// TestOptionsValidate is a unit test for Options.Validate.
+func TestOptionsValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		opts  Options
+		valid bool
+	}{
+		{Options{}, true},
+		{Options{AdminToken: "token"}, true},
+		{Options{TLSCertFile: "cert", TLSKeyFile: "key"}, true},
+		{Options{TLSCertFile: "cert", TLSKeyFile: "key", ClientCAFile: "ca"}, true},
+		{Options{TLSCertFile: "cert"}, false},
+		{Options{TLSKeyFile: "key"}, false},
+		{Options{ClientCAFile: "ca"}, false},
+		{Options{AddressUserLimit: RateLimit{Interval: time.Second}}, false},
+		{Options{AddressIPLimit: RateLimit{Interval: -time.Second, Burst: 1}}, false},
+		{DefaultOptions(), true},
+	}
+	for i, test := range tests {
+		if err := test.opts.Validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got %v", i, test.valid, err)
+		}
+	}
+}
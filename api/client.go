@@ -1,15 +1,21 @@
 package api
 
 import (
+	"context"
 	"fmt"
+	"math/big"
+	"net/url"
+	"time"
 
 	"github.com/SkynetLabs/siacoin-promoter/client"
+	"github.com/SkynetLabs/siacoin-promoter/promoter"
 	"go.sia.tech/siad/types"
 )
 
 // PromoterClient provides a library for communicating with the promoter's API.
 type PromoterClient struct {
 	*client.Client
+	staticAdminToken string
 }
 
 // NewClient creates a new PromoterClient.
@@ -19,6 +25,25 @@ func NewClient(addr string) *PromoterClient {
 	}
 }
 
+// NewAdminClient creates a new PromoterClient which authenticates with the
+// given token on admin routes.
+func NewAdminClient(addr, adminToken string) *PromoterClient {
+	return &PromoterClient{
+		Client:           client.NewClient(addr),
+		staticAdminToken: adminToken,
+	}
+}
+
+// adminHeaders returns the headers to send with requests to admin routes.
+func (c *PromoterClient) adminHeaders() map[string]string {
+	if c.staticAdminToken == "" {
+		return nil
+	}
+	return map[string]string{
+		"Authorization": "Bearer " + c.staticAdminToken,
+	}
+}
+
 // Address returns the active address for a given user to send money to. The
 // user is identified by the specified authentication header which should
 // contain a valid JWT.
@@ -28,10 +53,139 @@ func (c *PromoterClient) Address(headers map[string]string) (types.UnlockHash, e
 	return uap.Address, err
 }
 
-// MarkServerDead calls the /server/:servername endpoint to mark a server as
-// dead within the db.
+// Addresses returns all addresses that were ever assigned to the user
+// identified by the specified authentication headers.
+func (c *PromoterClient) Addresses(headers map[string]string) ([]promoter.UserAddress, error) {
+	var uag UserAddressesGET
+	err := c.Client.GetJSONWithHeaders("/addresses", headers, &uag)
+	return uag.Addresses, err
+}
+
+// UserAddresses returns all addresses that were ever assigned to the user with
+// the given sub. It requires admin credentials.
+func (c *PromoterClient) UserAddresses(sub string) ([]promoter.UserAddress, error) {
+	var uag UserAddressesGET
+	err := c.Client.GetJSONWithHeaders(fmt.Sprintf("/admin/users/%s/addresses", url.PathEscape(sub)), c.adminHeaders(), &uag)
+	return uag.Addresses, err
+}
+
+// RotateAddress replaces the user's primary address with a new one and returns
+// the new address. The user is identified by the specified authentication
+// headers.
+func (c *PromoterClient) RotateAddress(headers map[string]string) (types.UnlockHash, error) {
+	var uap UserAddressPOST
+	err := c.Client.PostJSONWithHeaders("/address/rotate", headers, &uap)
+	return uap.Address, err
+}
+
+// RotateUserAddress replaces the primary address of the user with the given
+// sub and returns the new address. It requires admin credentials.
+func (c *PromoterClient) RotateUserAddress(sub string) (types.UnlockHash, error) {
+	var uap UserAddressPOST
+	err := c.Client.PostJSONWithHeaders(fmt.Sprintf("/admin/users/%s/rotate", url.PathEscape(sub)), c.adminHeaders(), &uap)
+	return uap.Address, err
+}
+
+// ReactivateAddress moves a retired address back to the watched addresses. It
+// requires admin credentials.
+func (c *PromoterClient) ReactivateAddress(addr types.UnlockHash) error {
+	return c.Client.PostJSONWithHeaders(fmt.Sprintf("/admin/addresses/%s/reactivate", addr), c.adminHeaders(), nil)
+}
+
+// Backoffs returns the retry state of the promoter's background loops
+// including their last errors. It requires admin credentials.
+func (c *PromoterClient) Backoffs() (map[string]promoter.BackoffState, error) {
+	var bg BackoffsGET
+	err := c.GetJSONWithHeaders("/admin/backoffs", c.adminHeaders(), &bg)
+	return bg.Backoffs, err
+}
+
+// Pool returns the size and status of the pool of unused addresses. It requires
+// admin credentials.
+func (c *PromoterClient) Pool() (promoter.PoolStatus, error) {
+	var pg PoolGET
+	err := c.GetJSONWithHeaders("/admin/pool", c.adminHeaders(), &pg)
+	return pg.PoolStatus, err
+}
+
+// SetPoolSize changes the size of the pool of unused addresses. It requires
+// admin credentials.
+func (c *PromoterClient) SetPoolSize(ps promoter.PoolSize) error {
+	return c.PutJSONCtx(context.Background(), "/admin/pool", c.adminHeaders(), PoolPUT(ps), nil)
+}
+
+// Servers returns all known servers together with their state. It requires
+// admin credentials.
+func (c *PromoterClient) Servers() ([]promoter.Server, error) {
+	var sg ServersGET
+	err := c.GetJSONWithHeaders("/admin/servers", c.adminHeaders(), &sg)
+	return sg.Servers, err
+}
+
+// SetServerState changes the state of a server. It requires admin credentials.
+func (c *PromoterClient) SetServerState(server, state string) error {
+	path := fmt.Sprintf("/admin/servers/%s/state", url.PathEscape(server))
+	return c.PutJSONCtx(context.Background(), path, c.adminHeaders(), ServerStatePUT{State: state}, nil)
+}
+
+// ResyncServer makes a server re-add all addresses to its skyd and rescan the
+// blockchain. It requires admin credentials.
+func (c *PromoterClient) ResyncServer(server string) error {
+	return c.Client.PostJSONWithHeaders(fmt.Sprintf("/admin/servers/%s/resync", url.PathEscape(server)), c.adminHeaders(), nil)
+}
+
+// ResetWalletFingerprint makes a server accept the seed of its current address
+// generator. It requires admin credentials.
+func (c *PromoterClient) ResetWalletFingerprint(server string) error {
+	return c.Client.PostJSONWithHeaders(fmt.Sprintf("/admin/servers/%s/fingerprint/reset", url.PathEscape(server)), c.adminHeaders(), nil)
+}
+
+// MarkServerDead calls the /dead/:servername endpoint to mark a server as
+// dead within the db. It requires admin credentials.
 func (c *PromoterClient) MarkServerDead(server string) error {
-	return c.Client.Post(fmt.Sprintf("/dead/%s", server))
+	return c.Client.PostJSONWithHeaders(fmt.Sprintf("/dead/%s", server), c.adminHeaders(), nil)
+}
+
+// AuditLog calls the /admin/audit endpoint to fetch the entries of the audit
+// log that match the filter. It requires admin credentials.
+func (c *PromoterClient) AuditLog(filter promoter.AuditFilter) ([]promoter.AuditEntry, error) {
+	query := url.Values{}
+	if !filter.From.IsZero() {
+		query.Set("from", filter.From.Format(time.RFC3339))
+	}
+	if !filter.To.IsZero() {
+		query.Set("to", filter.To.Format(time.RFC3339))
+	}
+	if filter.UserSub != "" {
+		query.Set("user", filter.UserSub)
+	}
+	if filter.Server != "" {
+		query.Set("server", filter.Server)
+	}
+	if filter.Action != "" {
+		query.Set("action", filter.Action)
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", fmt.Sprint(filter.Limit))
+	}
+	var ag AuditGET
+	err := c.GetJSONWithHeaders(client.ResourceWithQuery("/admin/audit", query), c.adminHeaders(), &ag)
+	return ag.Entries, err
+}
+
+// SetConversionRate calls the /admin/conversionrate endpoint to update the
+// conversion rate from hastings to credits. It requires admin credentials.
+func (c *PromoterClient) SetConversionRate(rate *big.Rat) error {
+	return c.PutJSONCtx(context.Background(), "/admin/conversionrate", c.adminHeaders(), ConversionRatePUT{
+		Numerator:   rate.Num().String(),
+		Denominator: rate.Denom().String(),
+	}, nil)
+}
+
+// Metrics calls the /metrics endpoint on the server.
+func (c *PromoterClient) Metrics() (mg MetricsGET, err error) {
+	err = c.GetJSON("/metrics", &mg)
+	return
 }
 
 // Health calls the /health endpoint on the server.
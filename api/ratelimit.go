@@ -0,0 +1,177 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// rateLimiterPruneInterval is the interval at which buckets which are
+	// full again are removed from a rateLimiter.
+	rateLimiterPruneInterval = time.Minute
+)
+
+var (
+	// defaultRotateLimit allows a user to rotate their address 3 times in
+	// a row and then once every hour.
+	defaultRotateLimit = RateLimit{
+		Interval: time.Hour,
+		Burst:    3,
+	}
+
+	// defaultAddressUserLimit allows a user to request their address 10
+	// times in a row and then once every 6 seconds.
+	defaultAddressUserLimit = RateLimit{
+		Interval: 6 * time.Second,
+		Burst:    10,
+	}
+
+	// defaultAddressIPLimit allows a single IP to request addresses 60
+	// times in a row and then once per second. It's more permissive than
+	// the user limit since multiple users might share an IP.
+	defaultAddressIPLimit = RateLimit{
+		Interval: time.Second,
+		Burst:    60,
+	}
+)
+
+type (
+	// RateLimit describes a token bucket. Every request consumes a token
+	// and tokens are refilled at a fixed interval up to a max of Burst
+	// tokens. A zero Interval disables rate limiting.
+	RateLimit struct {
+		// Interval is the time it takes to refill a single token.
+		Interval time.Duration
+
+		// Burst is the max number of tokens in the bucket.
+		Burst int
+	}
+
+	// rateLimiter is a set of token buckets identified by a key.
+	rateLimiter struct {
+		staticLimit RateLimit
+
+		buckets   map[string]*tokenBucket
+		lastPrune time.Time
+		mu        sync.Mutex
+	}
+
+	// tokenBucket is a single bucket of a rateLimiter.
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// Validate checks the rate limit for invalid values.
+func (rl RateLimit) Validate() error {
+	if rl.Interval < 0 {
+		return errors.New("interval can't be negative")
+	}
+	if rl.Interval > 0 && rl.Burst <= 0 {
+		return errors.New("burst needs to be positive")
+	}
+	return nil
+}
+
+// newRateLimiter creates a new rateLimiter.
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		staticLimit: limit,
+		buckets:     make(map[string]*tokenBucket),
+		lastPrune:   time.Now(),
+	}
+}
+
+// refill refills the bucket according to the time that passed since it was
+// last updated.
+func (tb *tokenBucket) refill(now time.Time, limit RateLimit) {
+	elapsed := now.Sub(tb.last)
+	tb.tokens = math.Min(float64(limit.Burst), tb.tokens+float64(elapsed)/float64(limit.Interval))
+	tb.last = now
+}
+
+// managedAllow consumes a token from the bucket with the given key. If the
+// bucket is empty, false is returned together with the time until the next
+// token becomes available.
+func (rl *rateLimiter) managedAllow(key string) (bool, time.Duration) {
+	if rl.staticLimit.Interval == 0 {
+		return true, 0 // disabled
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.prune(now)
+
+	tb, exists := rl.buckets[key]
+	if !exists {
+		tb = &tokenBucket{
+			tokens: float64(rl.staticLimit.Burst),
+			last:   now,
+		}
+		rl.buckets[key] = tb
+	}
+	tb.refill(now, rl.staticLimit)
+	if tb.tokens < 1 {
+		missing := 1 - tb.tokens
+		return false, time.Duration(missing * float64(rl.staticLimit.Interval))
+	}
+	tb.tokens--
+	return true, 0
+}
+
+// prune removes all buckets which are full again since they behave the
+// same as a new bucket. The caller needs to hold the lock.
+func (rl *rateLimiter) prune(now time.Time) {
+	if now.Sub(rl.lastPrune) < rateLimiterPruneInterval {
+		return
+	}
+	rl.lastPrune = now
+	for key, tb := range rl.buckets {
+		tb.refill(now, rl.staticLimit)
+		if tb.tokens >= float64(rl.staticLimit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// clientIP returns the IP of the client that sent the request. If trustProxy is
+// true, the IP is taken from the X-Real-IP or the last entry of the
+// X-Forwarded-For header set by the reverse proxy in front of the API.
+func clientIP(req *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		if xff := req.Header.Get("X-Forwarded-For"); xff != "" {
+			ips := strings.Split(xff, ",")
+			if ip := strings.TrimSpace(ips[len(ips)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// writeRateLimited responds with 429 and sets the Retry-After header to the
+// number of seconds until the next request will be allowed.
+func (api *API) writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	api.WriteError(w, fmt.Errorf("rate limit exceeded, retry in %vs", seconds), http.StatusTooManyRequests)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRateLimiter is a unit test for the rateLimiter.
+func TestRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	interval := 100 * time.Millisecond
+	rl := newRateLimiter(RateLimit{Interval: interval, Burst: 2})
+
+	// The first 2 requests are allowed.
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.managedAllow("foo"); !ok {
+			t.Fatal("request should be allowed", i)
+		}
+	}
+
+	// The third one isn't.
+	ok, retryAfter := rl.managedAllow("foo")
+	if ok {
+		t.Fatal("request shouldn't be allowed")
+	}
+	if retryAfter <= 0 || retryAfter > interval {
+		t.Fatal("wrong retryAfter", retryAfter)
+	}
+
+	// Other keys are not affected.
+	if ok, _ := rl.managedAllow("bar"); !ok {
+		t.Fatal("request should be allowed")
+	}
+
+	// After waiting, another request is allowed.
+	time.Sleep(retryAfter)
+	if ok, _ := rl.managedAllow("foo"); !ok {
+		t.Fatal("request should be allowed")
+	}
+
+	// Pruning removes full buckets only.
+	rl.mu.Lock()
+	rl.prune(time.Now().Add(rateLimiterPruneInterval))
+	_, fooExists := rl.buckets["foo"]
+	_, barExists := rl.buckets["bar"]
+	rl.mu.Unlock()
+	if fooExists || barExists {
+		t.Fatal("refilled buckets should be pruned")
+	}
+
+	// A zero interval disables the limiter.
+	rl = newRateLimiter(RateLimit{})
+	for i := 0; i < 100; i++ {
+		if ok, _ := rl.managedAllow("foo"); !ok {
+			t.Fatal("request should be allowed")
+		}
+	}
+}
+
+// TestClientIP is a unit test for clientIP.
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		remoteAddr string
+		realIP     string
+		xff        string
+		trustProxy bool
+		result     string
+	}{
+		{"1.2.3.4:1234", "", "", false, "1.2.3.4"},
+		{"[::1]:1234", "", "", false, "::1"},
+		{"1.2.3.4", "", "", false, "1.2.3.4"},
+		{"1.2.3.4:1234", "5.6.7.8", "9.9.9.9", false, "1.2.3.4"},
+		{"1.2.3.4:1234", "5.6.7.8", "9.9.9.9", true, "5.6.7.8"},
+		{"1.2.3.4:1234", "", "9.9.9.9, 5.6.7.8", true, "5.6.7.8"},
+		{"1.2.3.4:1234", "", "", true, "1.2.3.4"},
+	}
+	for i, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/address", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.realIP != "" {
+			req.Header.Set("X-Real-IP", test.realIP)
+		}
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		if ip := clientIP(req, test.trustProxy); ip != test.result {
+			t.Errorf("%v: expected %v but got %v", i, test.result, ip)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/SkynetLabs/siacoin-promoter/promoter"
 	"github.com/julienschmidt/httprouter"
 	"gitlab.com/NebulousLabs/errors"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -10,10 +17,66 @@ import (
 )
 
 type (
-	// HealthGET is the type returned by the /health endpoint.
+	// HealthGET is the type returned by the /health endpoint. The backoffs
+	// only contain the number of failures and the time of the next retry.
+	// The errors are only exposed to admins through /admin/backoffs.
 	HealthGET struct {
-		DBAlive   bool `json:"dbalive"`
-		SkydAlive bool `json:"skydalive"`
+		DBAlive     bool                             `json:"dbalive"`
+		SkydAlive   bool                             `json:"skydalive"`
+		WalletMatch bool                             `json:"walletmatch"`
+		Backoffs    map[string]promoter.BackoffState `json:"backoffs"`
+	}
+
+	// BackoffsGET is the type returned by the /admin/backoffs endpoint.
+	BackoffsGET struct {
+		Backoffs map[string]promoter.BackoffState `json:"backoffs"`
+	}
+
+	// MetricsGET is the type returned by the /metrics endpoint.
+	MetricsGET struct {
+		AccountsCache promoter.AccountsCacheStats `json:"accountscache"`
+		PoolTarget    promoter.PoolTarget         `json:"pooltarget"`
+	}
+
+	// AuditGET is the type returned by the /admin/audit endpoint.
+	AuditGET struct {
+		Entries []promoter.AuditEntry `json:"entries"`
+	}
+
+	// ConversionRatePUT is the request body of the /admin/conversionrate
+	// endpoint. The rate is the number of credits a user receives per
+	// hasting.
+	ConversionRatePUT struct {
+		Numerator   string `json:"numerator"`
+		Denominator string `json:"denominator"`
+	}
+
+	// PoolGET is the type returned by the GET /admin/pool endpoint.
+	PoolGET struct {
+		promoter.PoolStatus
+	}
+
+	// PoolPUT is the request body of the PUT /admin/pool endpoint.
+	PoolPUT struct {
+		Min int64 `json:"min"`
+		Max int64 `json:"max"`
+	}
+
+	// ServersGET is the type returned by the GET /admin/servers endpoint.
+	ServersGET struct {
+		Servers []promoter.Server `json:"servers"`
+	}
+
+	// ServerStatePUT is the request body of the PUT
+	// /admin/servers/:server/state endpoint.
+	ServerStatePUT struct {
+		State string `json:"state"`
+	}
+
+	// UserAddressesGET is the type returned by the /addresses and
+	// /admin/users/:sub/addresses endpoints.
+	UserAddressesGET struct {
+		Addresses []promoter.UserAddress `json:"addresses"`
 	}
 
 	// UserAddressPOST is the type returned by the /address endpoint.
@@ -25,30 +88,87 @@ type (
 // buildHTTPRoutes registers the http routes with the httprouter.
 func (api *API) buildHTTPRoutes() {
 	api.staticRouter.GET("/health", api.healthGET)
+	api.staticRouter.GET("/metrics", api.metricsGET)
 	api.staticRouter.POST("/address", api.userAddressPOST)
-	api.staticRouter.POST("/dead/:servername", api.deadServerPOST)
+	api.staticRouter.POST("/address/rotate", api.userAddressRotatePOST)
+	api.staticRouter.GET("/addresses", api.userAddressesGET)
+
+	// Admin routes.
+	api.staticRouter.POST("/dead/:servername", api.adminHandler(api.deadServerPOST))
+	api.staticRouter.GET("/admin/audit", api.adminHandler(api.auditGET))
+	api.staticRouter.GET("/admin/backoffs", api.adminHandler(api.backoffsGET))
+	api.staticRouter.PUT("/admin/conversionrate", api.adminHandler(api.conversionRatePUT))
+	api.staticRouter.GET("/admin/users/:sub/addresses", api.adminHandler(api.adminUserAddressesGET))
+	api.staticRouter.POST("/admin/users/:sub/rotate", api.adminHandler(api.adminUserRotatePOST))
+	api.staticRouter.POST("/admin/addresses/:address/reactivate", api.adminHandler(api.adminAddressReactivatePOST))
+	api.staticRouter.GET("/admin/pool", api.adminHandler(api.poolGET))
+	api.staticRouter.PUT("/admin/pool", api.adminHandler(api.poolPUT))
+	api.staticRouter.GET("/admin/servers", api.adminHandler(api.serversGET))
+	api.staticRouter.PUT("/admin/servers/:server/state", api.adminHandler(api.serverStatePUT))
+	api.staticRouter.POST("/admin/servers/:server/resync", api.adminHandler(api.serverResyncPOST))
+	api.staticRouter.POST("/admin/servers/:server/fingerprint/reset", api.adminHandler(api.serverFingerprintResetPOST))
 }
 
 // healthGET returns the status of the service
 func (api *API) healthGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 	ph := api.staticPromoter.Health()
+	// Don't leak the errors of the background loops on this
+	// unauthenticated endpoint.
+	backoffs := make(map[string]promoter.BackoffState, len(ph.Backoffs))
+	for name, bs := range ph.Backoffs {
+		bs.LastError = ""
+		backoffs[name] = bs
+	}
 	api.WriteJSON(w, HealthGET{
-		DBAlive:   ph.Database == nil,
-		SkydAlive: ph.Skyd == nil,
+		DBAlive:     ph.Database == nil,
+		SkydAlive:   ph.Skyd == nil,
+		WalletMatch: ph.Wallet == nil,
+		Backoffs:    backoffs,
+	})
+}
+
+// backoffsGET is the handler for the /admin/backoffs endpoint. It returns the
+// retry state of the background loops including their last errors.
+func (api *API) backoffsGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	api.WriteJSON(w, BackoffsGET{
+		Backoffs: api.staticPromoter.Health().Backoffs,
+	})
+}
+
+// metricsGET returns metrics about the service.
+func (api *API) metricsGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	pm := api.staticPromoter.Metrics()
+	api.WriteJSON(w, MetricsGET{
+		AccountsCache: pm.AccountsCache,
+		PoolTarget:    pm.PoolTarget,
 	})
 }
 
 // userAddressPOST is the handler for the /address endpoint.
 func (api *API) userAddressPOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	// Check the IP rate limit before contacting the accounts service.
+	ip := clientIP(req, api.staticTrustProxyHeaders)
+	if ok, retryAfter := api.staticAddressIPLimiter.managedAllow(ip); !ok {
+		api.writeRateLimited(w, retryAfter)
+		return
+	}
+
 	// Get sub from accounts service.
-	sub, err := api.staticPromoter.SubFromAuthorizationHeader(req.Header)
+	sub, err := api.staticPromoter.SubFromAuthorizationHeader(req.Context(), req.Header)
 	if err != nil {
 		api.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
 
+	// Check the user rate limit.
+	if ok, retryAfter := api.staticAddressUserLimiter.managedAllow(sub); !ok {
+		api.writeRateLimited(w, retryAfter)
+		return
+	}
+
 	// Get address.
-	addr, err := api.staticPromoter.AddressForUser(req.Context(), sub)
+	ctx := promoter.ContextWithActor(req.Context(), "user:"+sub)
+	addr, err := api.staticPromoter.AddressForUser(ctx, sub)
 	if err != nil {
 		api.WriteError(w, err, http.StatusInternalServerError)
 		return
@@ -58,6 +178,111 @@ func (api *API) userAddressPOST(w http.ResponseWriter, req *http.Request, _ http
 	})
 }
 
+// userAddressesGET is the handler for the /addresses endpoint. It returns all
+// addresses that were ever assigned to the user.
+func (api *API) userAddressesGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	// Get sub from accounts service.
+	sub, err := api.staticPromoter.SubFromAuthorizationHeader(req.Context(), req.Header)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	api.userAddresses(req.Context(), w, sub)
+}
+
+// adminUserAddressesGET is the handler for the /admin/users/:sub/addresses
+// endpoint. It returns all addresses that were ever assigned to the specified
+// user.
+func (api *API) adminUserAddressesGET(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sub := ps.ByName("sub")
+	if sub == "" {
+		api.WriteError(w, errors.New("sub wasn't provided"), http.StatusBadRequest)
+		return
+	}
+	api.userAddresses(req.Context(), w, sub)
+}
+
+// userAddresses writes all addresses of a user to the response.
+func (api *API) userAddresses(ctx context.Context, w http.ResponseWriter, sub string) {
+	addrs, err := api.staticPromoter.UserAddresses(ctx, sub)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to fetch addresses"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, UserAddressesGET{
+		Addresses: addrs,
+	})
+}
+
+// userAddressRotatePOST is the handler for the /address/rotate endpoint. It
+// replaces the user's primary address with a new one.
+func (api *API) userAddressRotatePOST(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	// Get sub from accounts service.
+	sub, err := api.staticPromoter.SubFromAuthorizationHeader(req.Context(), req.Header)
+	if err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Check the rate limit.
+	if ok, retryAfter := api.staticRotateLimiter.managedAllow(sub); !ok {
+		api.writeRateLimited(w, retryAfter)
+		return
+	}
+
+	ctx := promoter.ContextWithActor(req.Context(), "user:"+sub)
+	api.rotateAddress(ctx, w, sub)
+}
+
+// adminUserRotatePOST is the handler for the /admin/users/:sub/rotate
+// endpoint. It replaces the primary address of the specified user.
+func (api *API) adminUserRotatePOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	sub := ps.ByName("sub")
+	if sub == "" {
+		api.WriteError(w, errors.New("sub wasn't provided"), http.StatusBadRequest)
+		return
+	}
+	api.rotateAddress(req.Context(), w, sub)
+}
+
+// rotateAddress rotates the address of a user and writes the new address to
+// the response.
+func (api *API) rotateAddress(ctx context.Context, w http.ResponseWriter, sub string) {
+	addr, err := api.staticPromoter.RotateAddress(ctx, sub)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		api.WriteError(w, errors.New("no unused addresses available, try again later"), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to rotate address"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, UserAddressPOST{
+		Address: addr,
+	})
+}
+
+// adminAddressReactivatePOST is the handler for the
+// /admin/addresses/:address/reactivate endpoint. It moves a retired address
+// back to the watched addresses to pick up late payments.
+func (api *API) adminAddressReactivatePOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	var addr types.UnlockHash
+	if err := addr.LoadString(ps.ByName("address")); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse address"), http.StatusBadRequest)
+		return
+	}
+	err := api.staticPromoter.ReactivateAddress(req.Context(), addr)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		api.WriteError(w, errors.New("address isn't archived"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to reactivate address"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // deadServerPOST is the handler for the /dead/:servername endpoint.
 func (api *API) deadServerPOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
 	server := ps.ByName("servername")
@@ -66,7 +291,7 @@ func (api *API) deadServerPOST(w http.ResponseWriter, req *http.Request, ps http
 		return
 	}
 
-	err := api.staticPromoter.MarkServerDead(server)
+	err := api.staticPromoter.MarkServerDead(req.Context(), server)
 	if errors.Contains(err, mongo.ErrNoDocuments) {
 		api.WriteError(w, errors.AddContext(err, "no server matches the given name"), http.StatusNotFound)
 		return
@@ -77,3 +302,179 @@ func (api *API) deadServerPOST(w http.ResponseWriter, req *http.Request, ps http
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// auditGET is the handler for the /admin/audit endpoint. It supports filtering
+// by time range, user, server and action using the query parameters 'from',
+// 'to', 'user', 'server' and 'action'. Times are either unix timestamps or
+// RFC3339 formatted.
+func (api *API) auditGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	q := req.URL.Query()
+	filter := promoter.AuditFilter{
+		UserSub: q.Get("user"),
+		Server:  q.Get("server"),
+		Action:  q.Get("action"),
+	}
+	var err error
+	if filter.From, err = parseTime(q.Get("from")); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse 'from'"), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseTime(q.Get("to")); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to parse 'to'"), http.StatusBadRequest)
+		return
+	}
+	if limit := q.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.ParseInt(limit, 10, 64)
+		if err != nil {
+			api.WriteError(w, errors.AddContext(err, "failed to parse 'limit'"), http.StatusBadRequest)
+			return
+		}
+	}
+	entries, err := api.staticPromoter.AuditEntries(req.Context(), filter)
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to fetch audit log"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, AuditGET{
+		Entries: entries,
+	})
+}
+
+// conversionRatePUT is the handler for the /admin/conversionrate endpoint.
+func (api *API) conversionRatePUT(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var crp ConversionRatePUT
+	if err := json.NewDecoder(req.Body).Decode(&crp); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to decode request body"), http.StatusBadRequest)
+		return
+	}
+	num, ok1 := new(big.Int).SetString(crp.Numerator, 10)
+	denom, ok2 := new(big.Int).SetString(crp.Denominator, 10)
+	if !ok1 || !ok2 || denom.Sign() == 0 {
+		api.WriteError(w, errors.New("numerator and denominator need to be integers and the denominator can't be 0"), http.StatusBadRequest)
+		return
+	}
+	rate := new(big.Rat).SetFrac(num, denom)
+	if rate.Sign() <= 0 {
+		api.WriteError(w, errors.New("conversion rate needs to be positive"), http.StatusBadRequest)
+		return
+	}
+	if err := api.staticPromoter.SetConversionRate(req.Context(), rate); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to set conversion rate"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// poolGET is the handler for the GET /admin/pool endpoint. It returns the size
+// of the pool of unused addresses and how many of them are left.
+func (api *API) poolGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ps, err := api.staticPromoter.PoolStatus(req.Context())
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to fetch pool status"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, PoolGET{
+		PoolStatus: ps,
+	})
+}
+
+// poolPUT is the handler for the PUT /admin/pool endpoint. It changes the size
+// of the pool of unused addresses.
+func (api *API) poolPUT(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	var pp PoolPUT
+	if err := json.NewDecoder(req.Body).Decode(&pp); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to decode request body"), http.StatusBadRequest)
+		return
+	}
+	ps := promoter.PoolSize(pp)
+	if err := ps.Validate(); err != nil {
+		api.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := api.staticPromoter.SetPoolSize(req.Context(), ps); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to set pool size"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serversGET is the handler for the GET /admin/servers endpoint. It returns all
+// known servers together with their state.
+func (api *API) serversGET(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	servers, err := api.staticPromoter.Servers(req.Context())
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to fetch servers"), http.StatusInternalServerError)
+		return
+	}
+	api.WriteJSON(w, ServersGET{
+		Servers: servers,
+	})
+}
+
+// serverStatePUT is the handler for the PUT /admin/servers/:server/state
+// endpoint. Draining a server stops the assignment of its addresses while they
+// are still watched. Setting the state to dead is the same as calling the
+// /dead/:servername endpoint.
+func (api *API) serverStatePUT(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	server := ps.ByName("server")
+	var ssp ServerStatePUT
+	if err := json.NewDecoder(req.Body).Decode(&ssp); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to decode request body"), http.StatusBadRequest)
+		return
+	}
+	if !promoter.ValidServerState(ssp.State) {
+		api.WriteError(w, fmt.Errorf("unknown server state '%v'", ssp.State), http.StatusBadRequest)
+		return
+	}
+	if err := api.staticPromoter.SetServerState(req.Context(), server, ssp.State); err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to set server state"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serverResyncPOST is the handler for the POST /admin/servers/:server/resync
+// endpoint. It makes the server re-add all addresses to its skyd and rescan the
+// blockchain. The progress is reported by the GET /admin/servers endpoint.
+func (api *API) serverResyncPOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	server := ps.ByName("server")
+	err := api.staticPromoter.ResyncServer(req.Context(), server)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		api.WriteError(w, errors.AddContext(err, "no server matches the given name"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to request resync"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// serverFingerprintResetPOST is the handler for the POST
+// /admin/servers/:server/fingerprint/reset endpoint. It makes the server accept
+// the seed of its current address generator.
+func (api *API) serverFingerprintResetPOST(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	server := ps.ByName("server")
+	err := api.staticPromoter.ResetWalletFingerprint(req.Context(), server)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		api.WriteError(w, errors.AddContext(err, "no server matches the given name"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, errors.AddContext(err, "failed to reset wallet fingerprint"), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseTime parses a unix timestamp or RFC3339 formatted time. An empty string
+// results in the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(unix, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -2,24 +2,68 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"gitlab.com/NebulousLabs/errors"
 )
 
+const (
+	// DefaultTimeout is the default timeout for a single request including
+	// reading the response body.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultMaxRetries is the default number of times an idempotent
+	// request is retried.
+	DefaultMaxRetries = 2
+
+	// DefaultRetryInterval is the default delay between two attempts of an
+	// idempotent request.
+	DefaultRetryInterval = 500 * time.Millisecond
+)
+
 type (
 	// Error is the error type returned by the API in case the status code
 	// is not a 2xx code.
 	Error struct {
 		Message string `json:"message"`
+
+		// StatusCode is the status code of the response that caused
+		// the error.
+		StatusCode int `json:"-"`
+	}
+
+	// Options contains the options for creating a Client.
+	Options struct {
+		// HTTPClient is the client used to execute requests. If it is
+		// nil, a new client with the configured Timeout is created.
+		HTTPClient *http.Client
+
+		// Timeout is the timeout for a single request. It is ignored
+		// if HTTPClient is set.
+		Timeout time.Duration
+
+		// MaxRetries is the number of times an idempotent request is
+		// retried after a network error or a 502, 503 or 504 response.
+		MaxRetries int
+
+		// RetryInterval is the delay between two attempts of an
+		// idempotent request.
+		RetryInterval time.Duration
 	}
 
 	// Client is a helper library for interacting with an API.
 	Client struct {
-		staticAddr string
+		staticAddr          string
+		staticHTTPClient    *http.Client
+		staticMaxRetries    int
+		staticRetryInterval time.Duration
 	}
 )
 
@@ -29,103 +73,258 @@ func (err Error) Error() string {
 	return err.Message
 }
 
-// NewClient creates a new Client for an API listening on the given address.
+// DefaultOptions returns the default options for a Client.
+func DefaultOptions() Options {
+	return Options{
+		Timeout:       DefaultTimeout,
+		MaxRetries:    DefaultMaxRetries,
+		RetryInterval: DefaultRetryInterval,
+	}
+}
+
+// NewClient creates a new Client for an API listening on the given address
+// using the default options.
 func NewClient(addr string) *Client {
+	return NewClientWithOptions(addr, DefaultOptions())
+}
+
+// NewClientWithOptions creates a new Client for an API listening on the given
+// address using custom options.
+func NewClientWithOptions(addr string, opts Options) *Client {
+	hc := opts.HTTPClient
+	if hc == nil {
+		hc = &http.Client{
+			Timeout: opts.Timeout,
+		}
+	}
 	return &Client{
-		staticAddr: addr,
+		staticAddr:          addr,
+		staticHTTPClient:    hc,
+		staticMaxRetries:    opts.MaxRetries,
+		staticRetryInterval: opts.RetryInterval,
 	}
 }
 
-// readAPIError decodes and returns an api.Error.
-func readAPIError(r io.Reader) error {
-	var apiErr Error
-	b, _ := ioutil.ReadAll(r)
-	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&apiErr); err != nil {
+// readAPIError decodes and returns an api.Error from a response. If the
+// response body doesn't contain a json encoded error, the raw body or the
+// status text is used as the message instead.
+func readAPIError(resp *http.Response) error {
+	apiErr := Error{
+		StatusCode: resp.StatusCode,
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
 		return errors.AddContext(err, "could not read error response")
 	}
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&apiErr); err == nil && apiErr.Message != "" {
+		return apiErr
+	}
+	apiErr.Message = strings.TrimSpace(string(b))
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
 	return apiErr
 }
 
-// do attaches the given headers to a request and then executes it using the
-// default client.
-func (c *Client) do(req *http.Request, headers map[string]string) (*http.Response, error) {
-	for k, v := range headers {
-		req.Header.Set(k, v)
+// ResourceWithQuery appends the encoded query parameters to a resource.
+func ResourceWithQuery(resource string, query url.Values) string {
+	if len(query) == 0 {
+		return resource
 	}
-	return http.DefaultClient.Do(req)
+	return resource + "?" + query.Encode()
 }
 
-// get performs a GET request on the provided resource.
-func (c *Client) get(resource string, headers map[string]string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", c.staticAddr+resource, nil)
-	if err != nil {
-		return nil, err
+// isIdempotent returns whether requests with the given method can safely be
+// retried.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	default:
+		return false
 	}
-	return c.do(req, headers)
 }
 
-// post performs a POST request on the provided resource.
-func (c *Client) post(resource string, headers map[string]string, body io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest("POST", c.staticAddr+resource, body)
+// shouldRetry returns whether a request that resulted in the given response
+// and error should be retried.
+func shouldRetry(resp *http.Response, err error) bool {
 	if err != nil {
-		return nil, err
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
 	}
-	return c.do(req, headers)
 }
 
-// GetJSONWithHeaders performs a GET request on the provided resource and tries
-// to json decode the response body into the provided object.
-func (c *Client) GetJSONWithHeaders(resource string, headers map[string]string, obj interface{}) error {
-	resp, err := c.get(resource, headers)
+// encodeBody turns a request body into a byte slice. Byte slices and readers
+// are sent as they are while any other non-nil value is json encoded.
+func encodeBody(reqBody interface{}) (b []byte, isJSON bool, err error) {
+	switch body := reqBody.(type) {
+	case nil:
+		return nil, false, nil
+	case []byte:
+		return body, false, nil
+	case io.Reader:
+		b, err = ioutil.ReadAll(body)
+		return b, false, err
+	default:
+		b, err = json.Marshal(body)
+		return b, true, err
+	}
+}
+
+// do creates a request for the given method and resource, attaches the given
+// headers and then executes it. Idempotent requests are retried up to
+// staticMaxRetries times.
+func (c *Client) do(ctx context.Context, method, resource string, headers map[string]string, body []byte) (*http.Response, error) {
+	var retries int
+	if isIdempotent(method) {
+		retries = c.staticMaxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		// Create a new reader for every attempt since the previous one
+		// might have been consumed.
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.staticAddr+resource, r)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := c.staticHTTPClient.Do(req)
+		if attempt >= retries || ctx.Err() != nil || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		// Discard the response before retrying.
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		t := time.NewTimer(c.staticRetryInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Do performs a request with the given method on the provided resource. A
+// non-nil reqBody is sent as the request body. Byte slices and readers are sent
+// as they are while any other value is json encoded. If respObj is not nil,
+// the response body is json decoded into it. Responses with a non-2xx status
+// code are returned as an Error. On success the headers of the response are
+// returned.
+func (c *Client) Do(ctx context.Context, method, resource string, headers map[string]string, reqBody, respObj interface{}) (http.Header, error) {
+	body, isJSON, err := encodeBody(reqBody)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to encode request body")
+	}
+	if isJSON {
+		h := make(map[string]string, len(headers)+1)
+		h["Content-Type"] = "application/json"
+		for k, v := range headers {
+			h[k] = v
+		}
+		headers = h
+	}
+	resp, err := c.do(ctx, method, resource, headers, body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// Check for 200 since we expect a successful response with body.
-	if resp.StatusCode != http.StatusOK {
-		return readAPIError(resp.Body)
+	// Check for 2xx since anything else is considered an error.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, readAPIError(resp)
+	}
+
+	// Decode the response if the caller expects one.
+	if respObj != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(respObj); err != nil {
+			return nil, errors.AddContext(err, "failed to decode response body")
+		}
 	}
+	return resp.Header, nil
+}
+
+// GetJSONWithHeadersCtx performs a GET request on the provided resource and
+// tries to json decode the response body into the provided object. The
+// request is aborted when the context is closed.
+func (c *Client) GetJSONWithHeadersCtx(ctx context.Context, resource string, headers map[string]string, obj interface{}) error {
+	_, err := c.Do(ctx, http.MethodGet, resource, headers, nil, obj)
+	return err
+}
+
+// GetJSONWithHeaders performs a GET request on the provided resource and tries
+// to json decode the response body into the provided object.
+func (c *Client) GetJSONWithHeaders(resource string, headers map[string]string, obj interface{}) error {
+	return c.GetJSONWithHeadersCtx(context.Background(), resource, headers, obj)
+}
 
-	dec := json.NewDecoder(resp.Body)
-	return dec.Decode(obj)
+// GetJSONCtx performs a GET request on the provided resource and tries to json
+// decode the response body into the provided object. The request is aborted
+// when the context is closed.
+func (c *Client) GetJSONCtx(ctx context.Context, resource string, obj interface{}) error {
+	return c.GetJSONWithHeadersCtx(ctx, resource, nil, obj)
 }
 
 // GetJSON performs a GET request on the provided resource and tries to json
 // decode the response body into the provided object.
 func (c *Client) GetJSON(resource string, obj interface{}) error {
-	return c.GetJSONWithHeaders(resource, nil, obj)
+	return c.GetJSONCtx(context.Background(), resource, obj)
+}
+
+// PostJSONWithHeadersCtx performs a POST request on the provided resource. The
+// request is aborted when the context is closed.
+func (c *Client) PostJSONWithHeadersCtx(ctx context.Context, resource string, headers map[string]string, obj interface{}) error {
+	return c.PostJSONWithBodyCtx(ctx, resource, headers, nil, obj)
 }
 
 // PostJSONWithHeaders performs a POST request o the provided resource.
 func (c *Client) PostJSONWithHeaders(resource string, headers map[string]string, obj interface{}) error {
-	resp, err := c.post(resource, headers, nil)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	return c.PostJSONWithHeadersCtx(context.Background(), resource, headers, obj)
+}
 
-	// Check for 200 since we expect a successful response with body.
-	if resp.StatusCode != http.StatusOK {
-		return readAPIError(resp.Body)
-	}
+// PostJSONWithBodyCtx performs a POST request with the json encoded reqBody on
+// the provided resource and decodes the response into respObj if it is not
+// nil.
+func (c *Client) PostJSONWithBodyCtx(ctx context.Context, resource string, headers map[string]string, reqBody, respObj interface{}) error {
+	_, err := c.Do(ctx, http.MethodPost, resource, headers, reqBody, respObj)
+	return err
+}
 
-	dec := json.NewDecoder(resp.Body)
-	return dec.Decode(obj)
+// PostCtx performs a simple post request to the resource without a body and
+// without expecting a response. The request is aborted when the context is
+// closed.
+func (c *Client) PostCtx(ctx context.Context, resource string) error {
+	return c.PostJSONWithBodyCtx(ctx, resource, nil, nil, nil)
 }
 
 // Post performs a simple post request to the resource without a body and
 // without expecting a response.
 func (c *Client) Post(resource string) error {
-	resp, err := c.post(resource, nil, nil)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+	return c.PostCtx(context.Background(), resource)
+}
 
-	// Check for 200 since we expect a successful response with body.
-	if resp.StatusCode != http.StatusOK {
-		return readAPIError(resp.Body)
-	}
-	return nil
+// PutJSONCtx performs a PUT request with the json encoded reqBody on the
+// provided resource and decodes the response into respObj if it is not nil.
+func (c *Client) PutJSONCtx(ctx context.Context, resource string, headers map[string]string, reqBody, respObj interface{}) error {
+	_, err := c.Do(ctx, http.MethodPut, resource, headers, reqBody, respObj)
+	return err
+}
+
+// DeleteCtx performs a DELETE request on the provided resource without
+// expecting a response.
+func (c *Client) DeleteCtx(ctx context.Context, resource string, headers map[string]string) error {
+	_, err := c.Do(ctx, http.MethodDelete, resource, headers, nil, nil)
+	return err
 }
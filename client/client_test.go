@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testResponse is the response object used by the test server.
+type testResponse struct {
+	Value string `json:"value"`
+}
+
+// newTestServer creates a server which fails the first n requests with the
+// given status code before responding with a testResponse. It returns the
+// server and a pointer to its request counter.
+func newTestServer(n uint64, code int) (*httptest.Server, *uint64) {
+	var requests uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddUint64(&requests, 1) <= n {
+			w.WriteHeader(code)
+			_ = json.NewEncoder(w).Encode(Error{Message: "failed"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(testResponse{Value: r.Header.Get("Value")})
+	}))
+	return srv, &requests
+}
+
+// TestClientRetries makes sure that only idempotent requests are retried.
+func TestClientRetries(t *testing.T) {
+	t.Parallel()
+
+	opts := DefaultOptions()
+	opts.RetryInterval = time.Millisecond
+
+	// GET requests are retried.
+	srv, requests := newTestServer(uint64(opts.MaxRetries), http.StatusServiceUnavailable)
+	defer srv.Close()
+	c := NewClientWithOptions(srv.URL, opts)
+	var tr testResponse
+	err := c.GetJSONWithHeaders("/", map[string]string{"Value": "foo"}, &tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Value != "foo" {
+		t.Fatal("wrong value", tr.Value)
+	}
+	if n := atomic.LoadUint64(requests); n != uint64(opts.MaxRetries)+1 {
+		t.Fatal("wrong number of requests", n)
+	}
+
+	// Client errors are not retried.
+	srv2, requests2 := newTestServer(1, http.StatusBadRequest)
+	defer srv2.Close()
+	c = NewClientWithOptions(srv2.URL, opts)
+	err = c.GetJSON("/", &tr)
+	if err == nil || err.Error() != "failed" {
+		t.Fatal("expected error", err)
+	}
+	if n := atomic.LoadUint64(requests2); n != 1 {
+		t.Fatal("wrong number of requests", n)
+	}
+
+	// POST requests are not retried.
+	srv3, requests3 := newTestServer(1, http.StatusServiceUnavailable)
+	defer srv3.Close()
+	c = NewClientWithOptions(srv3.URL, opts)
+	if err := c.Post("/"); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := atomic.LoadUint64(requests3); n != 1 {
+		t.Fatal("wrong number of requests", n)
+	}
+}
+
+// TestClientTimeout makes sure that requests respect the configured timeout
+// and the provided context.
+func TestClientTimeout(t *testing.T) {
+	t.Parallel()
+
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-block:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	// Request times out.
+	opts := DefaultOptions()
+	opts.MaxRetries = 0
+	opts.Timeout = 100 * time.Millisecond
+	c := NewClientWithOptions(srv.URL, opts)
+	var tr testResponse
+	start := time.Now()
+	if err := c.GetJSON("/", &tr); err == nil {
+		t.Fatal("expected timeout")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("request took too long")
+	}
+
+	// Request is cancelled by context.
+	opts.Timeout = 0
+	c = NewClientWithOptions(srv.URL, opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.GetJSONCtx(ctx, "/", &tr); err == nil || ctx.Err() == nil {
+		t.Fatal("expected deadline to be exceeded", err)
+	}
+}
+
+// TestClientDo is a unit test for Do and the helpers built on top of it.
+func TestClientDo(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/echo":
+			// Echo the method, query and json body.
+			var tr testResponse
+			if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			w.Header().Set("Method", r.Method)
+			_ = json.NewEncoder(w).Encode(testResponse{
+				Value: tr.Value + r.URL.Query().Get("suffix"),
+			})
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/plain":
+			http.Error(w, "plain error", http.StatusTeapot)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	c := NewClient(srv.URL)
+	ctx := context.Background()
+
+	// Send a json body with a query parameter and check the headers.
+	resource := ResourceWithQuery("/echo", url.Values{"suffix": []string{"bar"}})
+	var tr testResponse
+	h, err := c.Do(ctx, http.MethodPatch, resource, nil, testResponse{Value: "foo"}, &tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.Value != "foobar" {
+		t.Fatal("wrong value", tr.Value)
+	}
+	if h.Get("Method") != http.MethodPatch {
+		t.Fatal("wrong method", h.Get("Method"))
+	}
+
+	// Typed helpers.
+	if err := c.PutJSONCtx(ctx, "/echo", nil, testResponse{Value: "put"}, &tr); err != nil || tr.Value != "put" {
+		t.Fatal("put failed", err, tr.Value)
+	}
+	if err := c.PostJSONWithBodyCtx(ctx, "/echo", nil, testResponse{Value: "post"}, &tr); err != nil || tr.Value != "post" {
+		t.Fatal("post failed", err, tr.Value)
+	}
+
+	// No content shouldn't be decoded.
+	if err := c.DeleteCtx(ctx, "/empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.GetJSONCtx(ctx, "/empty", &tr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Non-json errors should still be decoded.
+	err = c.GetJSONCtx(ctx, "/plain", &tr)
+	apiErr, ok := err.(Error)
+	if !ok {
+		t.Fatalf("wrong error type %T", err)
+	}
+	if apiErr.Message != "plain error" || apiErr.StatusCode != http.StatusTeapot {
+		t.Fatal("wrong error", apiErr.Message, apiErr.StatusCode)
+	}
+
+	// Errors without a body use the status text.
+	err = c.DeleteCtx(ctx, "/unknown", nil)
+	apiErr, ok = err.(Error)
+	if !ok || apiErr.Message != http.StatusText(http.StatusNotFound) {
+		t.Fatal("wrong error", err)
+	}
+}
+
+// TestResourceWithQuery is a unit test for ResourceWithQuery.
+func TestResourceWithQuery(t *testing.T) {
+	t.Parallel()
+
+	if r := ResourceWithQuery("/foo", nil); r != "/foo" {
+		t.Fatal("wrong resource", r)
+	}
+	q := url.Values{}
+	q.Set("a", "1")
+	q.Set("b", "x y")
+	if r := ResourceWithQuery("/foo", q); r != "/foo?a=1&b=x+y" {
+		t.Fatal("wrong resource", r)
+	}
+}
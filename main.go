@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -23,6 +25,8 @@ type (
 	// from the environment vars.
 	config struct {
 		AccountsAPIAddr string
+		AccountsOpts    promoter.AccountsOptions
+		APIOpts         api.Options
 		LogLevel        logrus.Level
 		Port            int
 		DBURI           string
@@ -30,6 +34,7 @@ type (
 		DBPassword      string
 		ServerDomain    string
 		SkydOpts        client.Options
+		PromoterOpts    promoter.Options
 	}
 )
 
@@ -47,6 +52,35 @@ const (
 	// envAccountsPort is the port the accounts service listens on.
 	envAccountsPort = "ACCOUNTS_PORT"
 
+	// envAccountsTimeout is the timeout for requests to the accounts
+	// service.
+	envAccountsTimeout = "ACCOUNTS_TIMEOUT"
+
+	// envAccountsCacheSize is the max number of users for which the
+	// response of the accounts service is cached.
+	envAccountsCacheSize = "ACCOUNTS_CACHE_SIZE"
+
+	// envAccountsCacheTTL is the duration for which a successful response
+	// of the accounts service is cached.
+	envAccountsCacheTTL = "ACCOUNTS_CACHE_TTL"
+
+	// envAccountsNegativeCacheTTL is the duration for which a response of
+	// the accounts service is cached if the user's credentials were
+	// invalid.
+	envAccountsNegativeCacheTTL = "ACCOUNTS_NEGATIVE_CACHE_TTL"
+
+	// envAccountsLocalJWT enables verifying the portal's JWT locally
+	// instead of asking the accounts service for every request.
+	envAccountsLocalJWT = "ACCOUNTS_LOCAL_JWT"
+
+	// envAccountsJWKSPath is the path of the accounts service's JWKS
+	// endpoint.
+	envAccountsJWKSPath = "ACCOUNTS_JWKS_PATH"
+
+	// envAccountsJWKSRefreshInterval is the interval after which the JWKS
+	// is fetched again.
+	envAccountsJWKSRefreshInterval = "ACCOUNTS_JWKS_REFRESH_INTERVAL"
+
 	// envAPIShutdownTimeout is the timeout for gracefully shutting down the
 	// API before killing it.
 	envAPIShutdownTimeout = 20 * time.Second
@@ -80,16 +114,92 @@ const (
 	// envServerDomain is the environment variable for setting the domain of
 	// the server within the cluster.
 	envServerDomain = "SERVER_DOMAIN"
+
+	// envRetryMinInterval is the environment variable for setting the
+	// delay before retrying a failed background operation for the first
+	// time.
+	envRetryMinInterval = "SIACOIN_PROMOTER_RETRY_MIN_INTERVAL"
+
+	// envRetryMaxInterval is the environment variable for setting the cap
+	// of the delay between retries of failed background operations.
+	envRetryMaxInterval = "SIACOIN_PROMOTER_RETRY_MAX_INTERVAL"
+
+	// envRetryJitter is the environment variable for setting the fraction
+	// of the retry delay which is randomized.
+	envRetryJitter = "SIACOIN_PROMOTER_RETRY_JITTER"
+
+	// envRetentionDays is the environment variable for setting the number
+	// of days after which a demoted address that didn't receive any funds
+	// is retired. 0 disables retiring addresses.
+	envRetentionDays = "SIACOIN_PROMOTER_RETENTION_DAYS"
+
+	// envPoolMin and envPoolMax are the environment variables for setting
+	// the default size of the pool of unused addresses. A size set via the
+	// admin API takes precedence.
+	envPoolMin = "SIACOIN_PROMOTER_POOL_MIN"
+	envPoolMax = "SIACOIN_PROMOTER_POOL_MAX"
+
+	// envPoolCoverage is the environment variable for enabling adaptive
+	// pool sizing. It is the duration of demand the pool should cover,
+	// e.g. "6h".
+	envPoolCoverage = "SIACOIN_PROMOTER_POOL_COVERAGE"
+
+	// envPoolStrategy is the environment variable for setting the strategy
+	// for picking the server whose addresses are assigned to users.
+	envPoolStrategy = "SIACOIN_PROMOTER_POOL_STRATEGY"
+
+	// envAddressSeedFile is the environment variable for setting the path
+	// of a file containing a dedicated seed to derive addresses from
+	// instead of fetching them from skyd. The seed is read from a file to
+	// keep it out of the process environment.
+	envAddressSeedFile = "SIACOIN_PROMOTER_ADDRESS_SEED_FILE"
+
+	// envAdminToken is the environment variable for setting the shared
+	// secret required to access admin routes.
+	// nolint:gosec // this is not a credential
+	envAdminToken = "SIACOIN_PROMOTER_ADMIN_TOKEN"
+
+	// envTLSCertFile is the environment variable for setting the path of
+	// the certificate used to serve the API over TLS.
+	envTLSCertFile = "SIACOIN_PROMOTER_TLS_CERT_FILE"
+
+	// envTLSKeyFile is the environment variable for setting the path of
+	// the key used to serve the API over TLS.
+	envTLSKeyFile = "SIACOIN_PROMOTER_TLS_KEY_FILE"
+
+	// envTLSClientCAFile is the environment variable for setting the path
+	// of the CA used to verify admin client certificates.
+	envTLSClientCAFile = "SIACOIN_PROMOTER_TLS_CLIENT_CA_FILE"
+
+	// envAddressUserLimitInterval and envAddressUserLimitBurst are the
+	// environment variables for setting how often a single user can
+	// request an address.
+	envAddressUserLimitInterval = "SIACOIN_PROMOTER_ADDRESS_USER_LIMIT_INTERVAL"
+	envAddressUserLimitBurst    = "SIACOIN_PROMOTER_ADDRESS_USER_LIMIT_BURST"
+
+	// envAddressIPLimitInterval and envAddressIPLimitBurst are the
+	// environment variables for setting how often a single IP can request
+	// an address.
+	envAddressIPLimitInterval = "SIACOIN_PROMOTER_ADDRESS_IP_LIMIT_INTERVAL"
+	envAddressIPLimitBurst    = "SIACOIN_PROMOTER_ADDRESS_IP_LIMIT_BURST"
+
+	// envTrustProxyHeaders is the environment variable for enabling the
+	// use of the X-Real-IP and X-Forwarded-For headers to determine the
+	// client IP.
+	envTrustProxyHeaders = "SIACOIN_PROMOTER_TRUST_PROXY_HEADERS"
 )
 
 // parseConfig parses a Config struct from the environment.
 func parseConfig() (*config, error) {
 	// Create config with default vars.
 	cfg := &config{
-		LogLevel: logrus.InfoLevel,
+		AccountsOpts: promoter.DefaultAccountsOptions(),
+		APIOpts:      api.DefaultOptions(),
+		LogLevel:     logrus.InfoLevel,
 		SkydOpts: client.Options{
 			UserAgent: defaultSkydUserAgent,
 		},
+		PromoterOpts: promoter.DefaultOptions(),
 	}
 
 	// Parse custom vars from environment.
@@ -112,6 +222,55 @@ func parseConfig() (*config, error) {
 		return nil, fmt.Errorf("%s wasn't specified", envAccountsPort)
 	}
 	cfg.AccountsAPIAddr = fmt.Sprintf("%s:%s", accountsHostStr, accountsPortStr)
+	accountsTimeoutStr, ok := os.LookupEnv(envAccountsTimeout)
+	if ok {
+		cfg.AccountsOpts.Client.Timeout, err = time.ParseDuration(accountsTimeoutStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse accounts timeout")
+		}
+	}
+	accountsCacheSizeStr, ok := os.LookupEnv(envAccountsCacheSize)
+	if ok {
+		cfg.AccountsOpts.CacheSize, err = strconv.Atoi(accountsCacheSizeStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse accounts cache size")
+		}
+		if cfg.AccountsOpts.CacheSize < 0 {
+			return nil, fmt.Errorf("%s can't be negative", envAccountsCacheSize)
+		}
+	}
+	accountsCacheTTLStr, ok := os.LookupEnv(envAccountsCacheTTL)
+	if ok {
+		cfg.AccountsOpts.CacheTTL, err = time.ParseDuration(accountsCacheTTLStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse accounts cache ttl")
+		}
+	}
+	accountsNegativeCacheTTLStr, ok := os.LookupEnv(envAccountsNegativeCacheTTL)
+	if ok {
+		cfg.AccountsOpts.NegativeCacheTTL, err = time.ParseDuration(accountsNegativeCacheTTLStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse accounts negative cache ttl")
+		}
+	}
+	accountsLocalJWTStr, ok := os.LookupEnv(envAccountsLocalJWT)
+	if ok {
+		cfg.AccountsOpts.LocalJWT, err = strconv.ParseBool(accountsLocalJWTStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse accounts local jwt")
+		}
+	}
+	accountsJWKSPathStr, ok := os.LookupEnv(envAccountsJWKSPath)
+	if ok {
+		cfg.AccountsOpts.JWKSPath = accountsJWKSPathStr
+	}
+	accountsJWKSRefreshIntervalStr, ok := os.LookupEnv(envAccountsJWKSRefreshInterval)
+	if ok {
+		cfg.AccountsOpts.JWKSRefreshInterval, err = time.ParseDuration(accountsJWKSRefreshIntervalStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse jwks refresh interval")
+		}
+	}
 	cfg.DBURI, ok = os.LookupEnv(envMongoDBURI)
 	if !ok {
 		return nil, fmt.Errorf("%s wasn't specified", envMongoDBURI)
@@ -140,9 +299,116 @@ func parseConfig() (*config, error) {
 	if !ok {
 		return nil, fmt.Errorf("%s wasn't specified", envSiaAPIPassword)
 	}
+	retryMinStr, ok := os.LookupEnv(envRetryMinInterval)
+	if ok {
+		cfg.PromoterOpts.RetryMinInterval, err = time.ParseDuration(retryMinStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse min retry interval")
+		}
+	}
+	retryMaxStr, ok := os.LookupEnv(envRetryMaxInterval)
+	if ok {
+		cfg.PromoterOpts.RetryMaxInterval, err = time.ParseDuration(retryMaxStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse max retry interval")
+		}
+	}
+	retryJitterStr, ok := os.LookupEnv(envRetryJitter)
+	if ok {
+		cfg.PromoterOpts.RetryJitter, err = strconv.ParseFloat(retryJitterStr, 64)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse retry jitter")
+		}
+	}
+	retentionDaysStr, ok := os.LookupEnv(envRetentionDays)
+	if ok {
+		retentionDays, err := strconv.Atoi(retentionDaysStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse retention days")
+		}
+		cfg.PromoterOpts.RetentionPeriod = time.Duration(retentionDays) * 24 * time.Hour
+	}
+	poolMinStr, ok := os.LookupEnv(envPoolMin)
+	if ok {
+		cfg.PromoterOpts.PoolSize.Min, err = strconv.ParseInt(poolMinStr, 10, 64)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse min pool size")
+		}
+	}
+	poolMaxStr, ok := os.LookupEnv(envPoolMax)
+	if ok {
+		cfg.PromoterOpts.PoolSize.Max, err = strconv.ParseInt(poolMaxStr, 10, 64)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse max pool size")
+		}
+	}
+	poolCoverageStr, ok := os.LookupEnv(envPoolCoverage)
+	if ok {
+		cfg.PromoterOpts.PoolCoverage, err = time.ParseDuration(poolCoverageStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse pool coverage")
+		}
+	}
+	poolStrategy, ok := os.LookupEnv(envPoolStrategy)
+	if ok {
+		cfg.PromoterOpts.PoolStrategy = poolStrategy
+	}
+	seedFile, ok := os.LookupEnv(envAddressSeedFile)
+	if ok {
+		seed, err := os.ReadFile(seedFile)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to read address seed file")
+		}
+		cfg.PromoterOpts.AddressSeed = strings.TrimSpace(string(seed))
+	}
+	if err := cfg.PromoterOpts.Validate(); err != nil {
+		return nil, errors.AddContext(err, "invalid promoter options")
+	}
+	cfg.APIOpts.AdminToken = os.Getenv(envAdminToken)
+	cfg.APIOpts.TLSCertFile = os.Getenv(envTLSCertFile)
+	cfg.APIOpts.TLSKeyFile = os.Getenv(envTLSKeyFile)
+	cfg.APIOpts.ClientCAFile = os.Getenv(envTLSClientCAFile)
+	if err := parseRateLimit(envAddressUserLimitInterval, envAddressUserLimitBurst, &cfg.APIOpts.AddressUserLimit); err != nil {
+		return nil, errors.AddContext(err, "failed to parse address user limit")
+	}
+	if err := parseRateLimit(envAddressIPLimitInterval, envAddressIPLimitBurst, &cfg.APIOpts.AddressIPLimit); err != nil {
+		return nil, errors.AddContext(err, "failed to parse address ip limit")
+	}
+	trustProxyStr, ok := os.LookupEnv(envTrustProxyHeaders)
+	if ok {
+		cfg.APIOpts.TrustProxyHeaders, err = strconv.ParseBool(trustProxyStr)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse trust proxy headers")
+		}
+	}
+	if err := cfg.APIOpts.Validate(); err != nil {
+		return nil, errors.AddContext(err, "invalid api options")
+	}
 	return cfg, nil
 }
 
+// parseRateLimit overwrites the fields of the rate limit with the values of the
+// given environment variables if they are set.
+func parseRateLimit(intervalEnv, burstEnv string, rl *api.RateLimit) error {
+	intervalStr, ok := os.LookupEnv(intervalEnv)
+	if ok {
+		interval, err := time.ParseDuration(intervalStr)
+		if err != nil {
+			return errors.AddContext(err, "failed to parse interval")
+		}
+		rl.Interval = interval
+	}
+	burstStr, ok := os.LookupEnv(burstEnv)
+	if ok {
+		burst, err := strconv.Atoi(burstStr)
+		if err != nil {
+			return errors.AddContext(err, "failed to parse burst")
+		}
+		rl.Burst = burst
+	}
+	return nil
+}
+
 func main() {
 	logger := logrus.New()
 
@@ -169,20 +435,20 @@ func main() {
 	}
 
 	// Connect to accounts.
-	accountsClient := promoter.NewAccountsClient(cfg.AccountsAPIAddr)
+	accountsClient := promoter.NewAccountsClientWithOptions(cfg.AccountsAPIAddr, cfg.AccountsOpts)
 	_, err = accountsClient.Health()
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to connect to accounts")
 	}
 
 	// Create the promoter that talks to skyd and the database.
-	db, err := promoter.New(ctx, dependencies.ProdDependencies, accountsClient, skydClient, dbLogger, cfg.DBURI, cfg.DBUser, cfg.DBPassword, cfg.ServerDomain, dbName)
+	db, err := promoter.New(ctx, dependencies.ProdDependencies, accountsClient, skydClient, dbLogger, cfg.DBURI, cfg.DBUser, cfg.DBPassword, cfg.ServerDomain, dbName, cfg.PromoterOpts)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to connect to database")
 	}
 
 	// Create API.
-	api, err := api.New(apiLogger, db, cfg.Port)
+	api, err := api.New(apiLogger, db, cfg.Port, cfg.APIOpts)
 	if err != nil {
 		logger.WithError(err).Fatal("Failed to init API")
 	}
@@ -208,6 +474,9 @@ func main() {
 	}()
 
 	// Start serving API.
+	if cfg.APIOpts.AdminToken == "" && cfg.APIOpts.ClientCAFile == "" {
+		logger.Warn("Neither an admin token nor a client CA was configured. Admin routes are disabled.")
+	}
 	err = api.ListenAndServe()
 	if err != nil && !errors.Contains(err, http.ErrServerClosed) {
 		logger.WithError(err).Error("ListenAndServe returned an error")
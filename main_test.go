@@ -3,11 +3,18 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/SkynetLabs/siacoin-promoter/api"
+	"github.com/SkynetLabs/siacoin-promoter/promoter"
 	"github.com/sirupsen/logrus"
+	mnemonics "gitlab.com/NebulousLabs/entropy-mnemonics"
 	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
 	"gitlab.com/SkynetLabs/skyd/node/api/client"
+	"go.sia.tech/siad/modules"
 )
 
 // TestParseConfig is a unit test for parseConfig.
@@ -33,7 +40,12 @@ func TestParseConfig(t *testing.T) {
 		err8 := os.Setenv(envServerDomain, serverDomain)
 		err9 := os.Setenv(envAccountsHost, accountHost)
 		err10 := os.Setenv(envAccountsPort, accountPort)
-		if err := errors.Compose(err1, err2, err3, err4, err5, err6, err7, err8, err9, err10); err != nil {
+		// Start every case with the default retry options since they
+		// are validated against each other.
+		err11 := os.Unsetenv(envRetryMinInterval)
+		err12 := os.Unsetenv(envRetryMaxInterval)
+		err13 := os.Unsetenv(envRetryJitter)
+		if err := errors.Compose(err1, err2, err3, err4, err5, err6, err7, err8, err9, err10, err11, err12, err13); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -86,7 +98,32 @@ func TestParseConfig(t *testing.T) {
 		err8 := os.Unsetenv(envServerDomain)
 		err9 := os.Unsetenv(envAccountsHost)
 		err10 := os.Unsetenv(envAccountsPort)
-		if err := errors.Compose(err1, err2, err3, err4, err5, err6, err7, err8, err9, err10); err != nil {
+		err11 := os.Unsetenv(envRetryMinInterval)
+		err12 := os.Unsetenv(envRetryMaxInterval)
+		err13 := os.Unsetenv(envRetryJitter)
+		err14 := os.Unsetenv(envAccountsTimeout)
+		err15 := os.Unsetenv(envAccountsCacheSize)
+		err16 := os.Unsetenv(envAccountsCacheTTL)
+		err17 := os.Unsetenv(envAccountsNegativeCacheTTL)
+		err18 := os.Unsetenv(envAccountsLocalJWT)
+		err19 := os.Unsetenv(envAccountsJWKSPath)
+		err20 := os.Unsetenv(envAccountsJWKSRefreshInterval)
+		err21 := os.Unsetenv(envAdminToken)
+		err22 := os.Unsetenv(envTLSCertFile)
+		err23 := os.Unsetenv(envTLSKeyFile)
+		err24 := os.Unsetenv(envTLSClientCAFile)
+		err25 := os.Unsetenv(envRetentionDays)
+		err26 := os.Unsetenv(envAddressUserLimitInterval)
+		err27 := os.Unsetenv(envAddressUserLimitBurst)
+		err28 := os.Unsetenv(envAddressIPLimitInterval)
+		err29 := os.Unsetenv(envAddressIPLimitBurst)
+		err30 := os.Unsetenv(envTrustProxyHeaders)
+		err31 := os.Unsetenv(envPoolMin)
+		err32 := os.Unsetenv(envPoolMax)
+		err33 := os.Unsetenv(envPoolCoverage)
+		err34 := os.Unsetenv(envPoolStrategy)
+		err35 := os.Unsetenv(envAddressSeedFile)
+		if err := errors.Compose(err1, err2, err3, err4, err5, err6, err7, err8, err9, err10, err11, err12, err13, err14, err15, err16, err17, err18, err19, err20, err21, err22, err23, err24, err25, err26, err27, err28, err29, err30, err31, err32, err33, err34, err35); err != nil {
 			t.Fatal(err)
 		}
 	}()
@@ -197,4 +234,347 @@ func TestParseConfig(t *testing.T) {
 	if !errors.Contains(err, errParseFailed) {
 		t.Fatal(err)
 	}
+
+	// Case 11: Default retry options.
+	setEnv()
+	cfg, err := parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PromoterOpts != promoter.DefaultOptions() {
+		t.Fatal("expected default options", cfg.PromoterOpts)
+	}
+
+	// Case 12: Custom retry options.
+	setEnv()
+	err1 := os.Setenv(envRetryMinInterval, "3s")
+	err2 := os.Setenv(envRetryMaxInterval, "1h")
+	err3 := os.Setenv(envRetryJitter, "0.25")
+	if err := errors.Compose(err1, err2, err3); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PromoterOpts.RetryMinInterval != 3*time.Second {
+		t.Fatal("wrong min interval", cfg.PromoterOpts.RetryMinInterval)
+	}
+	if cfg.PromoterOpts.RetryMaxInterval != time.Hour {
+		t.Fatal("wrong max interval", cfg.PromoterOpts.RetryMaxInterval)
+	}
+	if cfg.PromoterOpts.RetryJitter != 0.25 {
+		t.Fatal("wrong jitter", cfg.PromoterOpts.RetryJitter)
+	}
+	err1 = os.Unsetenv(envRetryMinInterval)
+	err2 = os.Unsetenv(envRetryMaxInterval)
+	err3 = os.Unsetenv(envRetryJitter)
+	if err := errors.Compose(err1, err2, err3); err != nil {
+		t.Fatal(err)
+	}
+
+	// Case 13: Max interval smaller than min interval.
+	setEnv()
+	err1 = os.Setenv(envRetryMinInterval, "3s")
+	err2 = os.Setenv(envRetryMaxInterval, "1s")
+	if err := errors.Compose(err1, err2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+	err1 = os.Unsetenv(envRetryMinInterval)
+	err2 = os.Unsetenv(envRetryMaxInterval)
+	if err := errors.Compose(err1, err2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Case 14: Invalid jitter.
+	setEnv()
+	if err := os.Setenv(envRetryJitter, "2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+	if err := os.Unsetenv(envRetryJitter); err != nil {
+		t.Fatal(err)
+	}
+
+	// Case 15: Default accounts options.
+	setEnv()
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AccountsOpts != promoter.DefaultAccountsOptions() {
+		t.Fatal("wrong accounts options", cfg.AccountsOpts)
+	}
+
+	// Case 16: Custom accounts options.
+	setEnv()
+	err1 = os.Setenv(envAccountsTimeout, "5s")
+	err2 = os.Setenv(envAccountsCacheSize, "100")
+	err3 = os.Setenv(envAccountsCacheTTL, "2m")
+	err4 := os.Setenv(envAccountsNegativeCacheTTL, "3s")
+	if err := errors.Compose(err1, err2, err3, err4); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AccountsOpts.Client.Timeout != 5*time.Second {
+		t.Fatal("wrong accounts timeout", cfg.AccountsOpts.Client.Timeout)
+	}
+	if cfg.AccountsOpts.CacheSize != 100 {
+		t.Fatal("wrong cache size", cfg.AccountsOpts.CacheSize)
+	}
+	if cfg.AccountsOpts.CacheTTL != 2*time.Minute {
+		t.Fatal("wrong cache ttl", cfg.AccountsOpts.CacheTTL)
+	}
+	if cfg.AccountsOpts.NegativeCacheTTL != 3*time.Second {
+		t.Fatal("wrong negative cache ttl", cfg.AccountsOpts.NegativeCacheTTL)
+	}
+
+	// Case 17: Negative cache size.
+	setEnv()
+	if err := os.Setenv(envAccountsCacheSize, "-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 18: Local JWT validation.
+	setEnv()
+	err1 = os.Setenv(envAccountsLocalJWT, "true")
+	err2 = os.Setenv(envAccountsJWKSPath, "/jwks")
+	err3 = os.Setenv(envAccountsJWKSRefreshInterval, "10m")
+	err4 = os.Unsetenv(envAccountsCacheSize)
+	if err := errors.Compose(err1, err2, err3, err4); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.AccountsOpts.LocalJWT {
+		t.Fatal("local jwt should be enabled")
+	}
+	if cfg.AccountsOpts.JWKSPath != "/jwks" {
+		t.Fatal("wrong jwks path", cfg.AccountsOpts.JWKSPath)
+	}
+	if cfg.AccountsOpts.JWKSRefreshInterval != 10*time.Minute {
+		t.Fatal("wrong jwks refresh interval", cfg.AccountsOpts.JWKSRefreshInterval)
+	}
+
+	// Case 19: Invalid local JWT flag.
+	setEnv()
+	if err := os.Setenv(envAccountsLocalJWT, "maybe"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 20: API options.
+	setEnv()
+	err1 = os.Unsetenv(envAccountsLocalJWT)
+	err2 = os.Setenv(envAdminToken, "token")
+	err3 = os.Setenv(envTLSCertFile, "cert.pem")
+	err4 = os.Setenv(envTLSKeyFile, "key.pem")
+	err5 := os.Setenv(envTLSClientCAFile, "ca.pem")
+	if err := errors.Compose(err1, err2, err3, err4, err5); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedAPIOpts := api.DefaultOptions()
+	expectedAPIOpts.AdminToken = "token"
+	expectedAPIOpts.TLSCertFile = "cert.pem"
+	expectedAPIOpts.TLSKeyFile = "key.pem"
+	expectedAPIOpts.ClientCAFile = "ca.pem"
+	if cfg.APIOpts != expectedAPIOpts {
+		t.Fatal("wrong api options", cfg.APIOpts)
+	}
+
+	// Case 21: Client CA without TLS.
+	setEnv()
+	err1 = os.Unsetenv(envTLSCertFile)
+	err2 = os.Unsetenv(envTLSKeyFile)
+	if err := errors.Compose(err1, err2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 22: Retention period.
+	setEnv()
+	err1 = os.Unsetenv(envTLSClientCAFile)
+	err2 = os.Setenv(envRetentionDays, "30")
+	if err := errors.Compose(err1, err2); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PromoterOpts.RetentionPeriod != 30*24*time.Hour {
+		t.Fatal("wrong retention period", cfg.PromoterOpts.RetentionPeriod)
+	}
+
+	// Case 23: Negative retention period.
+	setEnv()
+	if err := os.Setenv(envRetentionDays, "-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 24: Address rate limits.
+	setEnv()
+	err1 = os.Unsetenv(envRetentionDays)
+	err2 = os.Setenv(envAddressUserLimitInterval, "1m")
+	err3 = os.Setenv(envAddressUserLimitBurst, "5")
+	err4 = os.Setenv(envAddressIPLimitInterval, "0s")
+	err5 = os.Setenv(envTrustProxyHeaders, "true")
+	err6 := os.Unsetenv(envAdminToken)
+	if err := errors.Compose(err1, err2, err3, err4, err5, err6); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedAPIOpts = api.DefaultOptions()
+	expectedAPIOpts.AddressUserLimit = api.RateLimit{Interval: time.Minute, Burst: 5}
+	expectedAPIOpts.AddressIPLimit.Interval = 0
+	expectedAPIOpts.TrustProxyHeaders = true
+	if cfg.APIOpts != expectedAPIOpts {
+		t.Fatal("wrong api options", cfg.APIOpts)
+	}
+
+	// Case 25: Invalid burst.
+	setEnv()
+	if err := os.Setenv(envAddressUserLimitBurst, "0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 26: Pool size.
+	setEnv()
+	err1 = os.Unsetenv(envAddressUserLimitBurst)
+	err2 = os.Setenv(envPoolMin, "20")
+	err3 = os.Setenv(envPoolMax, "30")
+	if err := errors.Compose(err1, err2, err3); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := (promoter.PoolSize{Min: 20, Max: 30}); cfg.PromoterOpts.PoolSize != ps {
+		t.Fatal("wrong pool size", cfg.PromoterOpts.PoolSize)
+	}
+	if cfg.PromoterOpts.PoolCoverage != 0 {
+		t.Fatal("adaptive pool sizing should be disabled by default")
+	}
+
+	// Case 27: Max pool size smaller than min.
+	setEnv()
+	if err := os.Setenv(envPoolMax, "10"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 28: Pool coverage.
+	setEnv()
+	err1 = os.Unsetenv(envPoolMin)
+	err2 = os.Unsetenv(envPoolMax)
+	err3 = os.Setenv(envPoolCoverage, "6h")
+	if err := errors.Compose(err1, err2, err3); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PromoterOpts.PoolCoverage != 6*time.Hour {
+		t.Fatal("wrong pool coverage", cfg.PromoterOpts.PoolCoverage)
+	}
+
+	// Case 29: Pool strategy.
+	setEnv()
+	if err := os.Setenv(envPoolStrategy, promoter.PoolStrategyLocal); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PromoterOpts.PoolStrategy != promoter.PoolStrategyLocal {
+		t.Fatal("wrong pool strategy", cfg.PromoterOpts.PoolStrategy)
+	}
+
+	// Case 30: Unknown pool strategy.
+	setEnv()
+	if err := os.Setenv(envPoolStrategy, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 31: Address seed.
+	setEnv()
+	if err := os.Unsetenv(envPoolStrategy); err != nil {
+		t.Fatal(err)
+	}
+	var seed modules.Seed
+	fastrand.Read(seed[:])
+	seedStr, err := modules.SeedToString(seed, mnemonics.English)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedFile := filepath.Join(t.TempDir(), "seed")
+	if err := os.WriteFile(seedFile, []byte(seedStr+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Setenv(envAddressSeedFile, seedFile); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = parseConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PromoterOpts.AddressSeed != seedStr {
+		t.Fatal("wrong address seed")
+	}
+
+	// Case 32: Invalid address seed.
+	setEnv()
+	if err := os.WriteFile(seedFile, []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Case 33: Missing address seed file.
+	setEnv()
+	if err := os.Setenv(envAddressSeedFile, filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseConfig(); err == nil {
+		t.Fatal("should fail")
+	}
 }
@@ -1,16 +1,67 @@
 package promoter
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/SkynetLabs/siacoin-promoter/client"
 )
 
+const (
+	// defaultAccountsCacheSize is the default number of users cached by
+	// the AccountsClient.
+	defaultAccountsCacheSize = 10000
+
+	// defaultAccountsCacheTTL is the default duration for which a
+	// successful user lookup is cached.
+	defaultAccountsCacheTTL = time.Minute
+
+	// defaultAccountsNegativeCacheTTL is the default duration for which a
+	// lookup with invalid credentials is cached.
+	defaultAccountsNegativeCacheTTL = 10 * time.Second
+)
+
 type (
 	// AccountsClient wraps the helper client with account service specific
 	// code.
 	AccountsClient struct {
 		*client.Client
+		staticCache *userCache
+
+		// staticJWT verifies tokens locally. It is nil if local JWT
+		// validation is disabled.
+		staticJWT *jwtVerifier
+	}
+
+	// AccountsOptions contains the options for creating an AccountsClient.
+	AccountsOptions struct {
+		// Client contains the options of the underlying http client.
+		Client client.Options
+
+		// CacheSize is the max number of users to cache. 0 disables
+		// the cache.
+		CacheSize int
+
+		// CacheTTL is the duration for which a successful user lookup
+		// is cached.
+		CacheTTL time.Duration
+
+		// NegativeCacheTTL is the duration for which a lookup is cached
+		// if it failed due to invalid credentials.
+		NegativeCacheTTL time.Duration
+
+		// LocalJWT enables verifying the portal's JWT locally using the
+		// keys published by the accounts service. If local validation
+		// fails, the /user endpoint is used instead.
+		LocalJWT bool
+
+		// JWKSPath is the path of the accounts service's JWKS endpoint.
+		JWKSPath string
+
+		// JWKSRefreshInterval is the interval after which the JWKS is
+		// fetched again.
+		JWKSRefreshInterval time.Duration
 	}
 
 	// AccountsHealthGET defines the structure of the account service's
@@ -26,12 +77,40 @@ type (
 	}
 )
 
+// DefaultAccountsOptions returns the default options for an AccountsClient.
+func DefaultAccountsOptions() AccountsOptions {
+	return AccountsOptions{
+		Client:              client.DefaultOptions(),
+		CacheSize:           defaultAccountsCacheSize,
+		CacheTTL:            defaultAccountsCacheTTL,
+		NegativeCacheTTL:    defaultAccountsNegativeCacheTTL,
+		JWKSPath:            defaultJWKSPath,
+		JWKSRefreshInterval: defaultJWKSRefreshInterval,
+	}
+}
+
 // NewAccountsClient creates a new client to communicate with the accounts
 // service API.
 func NewAccountsClient(address string) *AccountsClient {
-	return &AccountsClient{
-		Client: client.NewClient(address),
+	return NewAccountsClientWithOptions(address, DefaultAccountsOptions())
+}
+
+// NewAccountsClientWithOptions creates a new client to communicate with the
+// accounts service API using custom options.
+func NewAccountsClientWithOptions(address string, opts AccountsOptions) *AccountsClient {
+	ac := &AccountsClient{
+		Client:      client.NewClientWithOptions(address, opts.Client),
+		staticCache: newUserCache(opts.CacheSize, opts.CacheTTL, opts.NegativeCacheTTL),
 	}
+	if opts.LocalJWT {
+		ac.staticJWT = newJWTVerifier(ac.Client, opts.JWKSPath, opts.JWKSRefreshInterval)
+	}
+	return ac
+}
+
+// CacheStats returns statistics about the client's user cache.
+func (ac *AccountsClient) CacheStats() AccountsCacheStats {
+	return ac.staticCache.managedStats()
 }
 
 // Health calls the /health endpoint on the accounts service.
@@ -40,20 +119,38 @@ func (ac *AccountsClient) Health() (ahg AccountsHealthGET, err error) {
 	return
 }
 
-// UserSub uses the /user endpoint of the accounts service to return the user's
-// sub.
-func (ac *AccountsClient) UserSub(headers http.Header) (string, error) {
-	var aug AccountsUserGET
+// UserSub returns the user's sub. If local JWT validation is enabled, the
+// user's token is verified locally first. Otherwise or if that fails, the
+// /user endpoint of the accounts service is used. The request is aborted when
+// the context is closed. Results of the /user endpoint are cached by the
+// credentials within the headers.
+func (ac *AccountsClient) UserSub(ctx context.Context, headers http.Header) (string, error) {
+	if ac.staticJWT != nil {
+		sub, err := ac.staticJWT.managedSub(ctx, headers)
+		if err == nil {
+			return sub, nil
+		}
+	}
+
 	forwardedHeaders := map[string]string{
 		"Authorization": headers.Get("Authorization"),
 		"Cookie":        headers.Get("Cookie"),
 	}
-	err := ac.GetJSONWithHeaders("/user", forwardedHeaders, &aug)
+
+	// Check the cache first.
+	key := userCacheKey(forwardedHeaders["Authorization"], forwardedHeaders["Cookie"])
+	if entry, ok := ac.staticCache.managedGet(key); ok {
+		return entry.sub, entry.err
+	}
+
+	var aug AccountsUserGET
+	err := ac.GetJSONWithHeadersCtx(ctx, "/user", forwardedHeaders, &aug)
+	ac.staticCache.managedPut(key, aug.Sub, err)
 	return aug.Sub, err
 }
 
 // SubFromAuthorizationHeader is a convenience method to expose the client's
 // UserSub method through the promoter interface.
-func (p *Promoter) SubFromAuthorizationHeader(headers http.Header) (string, error) {
-	return p.staticAccounts.UserSub(headers)
+func (p *Promoter) SubFromAuthorizationHeader(ctx context.Context, headers http.Header) (string, error) {
+	return p.staticAccounts.UserSub(ctx, headers)
 }
@@ -0,0 +1,163 @@
+package promoter
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SkynetLabs/siacoin-promoter/client"
+)
+
+type (
+	// AccountsCacheStats contains statistics about the cache of the
+	// AccountsClient.
+	AccountsCacheStats struct {
+		Hits    uint64  `json:"hits"`
+		Misses  uint64  `json:"misses"`
+		HitRate float64 `json:"hitrate"`
+		Size    int     `json:"size"`
+	}
+
+	// userCache is a bounded LRU cache that maps the credentials a user
+	// sent with a request to the user's sub. Failed lookups due to invalid
+	// credentials are cached as well but with a separate TTL.
+	userCache struct {
+		staticMaxSize     int
+		staticTTL         time.Duration
+		staticNegativeTTL time.Duration
+
+		entries map[[sha256.Size]byte]*list.Element
+		lru     *list.List
+		hits    uint64
+		misses  uint64
+		mu      sync.Mutex
+	}
+
+	// userCacheEntry is a single entry of the userCache.
+	userCacheEntry struct {
+		key     [sha256.Size]byte
+		sub     string
+		err     error
+		expires time.Time
+	}
+)
+
+// newUserCache creates a new cache. A maxSize of 0 disables caching.
+func newUserCache(maxSize int, ttl, negativeTTL time.Duration) *userCache {
+	return &userCache{
+		staticMaxSize:     maxSize,
+		staticTTL:         ttl,
+		staticNegativeTTL: negativeTTL,
+		entries:           make(map[[sha256.Size]byte]*list.Element),
+		lru:               list.New(),
+	}
+}
+
+// userCacheKey returns the key for the credentials within the given headers.
+// We only keep a hash of the credentials in memory.
+func userCacheKey(authorization, cookie string) [sha256.Size]byte {
+	h := sha256.New()
+	_, _ = h.Write([]byte(authorization))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(cookie))
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// isInvalidCredentialsError returns whether the error returned by the accounts
+// service indicates that the provided credentials are invalid. Only those
+// errors are cached.
+func isInvalidCredentialsError(err error) bool {
+	apiErr, ok := err.(client.Error)
+	if !ok {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	default:
+		return false
+	}
+}
+
+// managedGet returns the cached result of a lookup. The second return value
+// indicates whether the cache contained a valid entry.
+func (uc *userCache) managedGet(key [sha256.Size]byte) (userCacheEntry, bool) {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if uc.staticMaxSize == 0 {
+		return userCacheEntry{}, false
+	}
+	e, exists := uc.entries[key]
+	if !exists {
+		uc.misses++
+		return userCacheEntry{}, false
+	}
+	entry := e.Value.(*userCacheEntry)
+	if time.Now().After(entry.expires) {
+		uc.lru.Remove(e)
+		delete(uc.entries, key)
+		uc.misses++
+		return userCacheEntry{}, false
+	}
+	uc.lru.MoveToFront(e)
+	uc.hits++
+	return *entry, true
+}
+
+// managedPut adds the result of a lookup to the cache. Errors are only cached
+// if they indicate invalid credentials. If the cache is full, the least
+// recently used entry is evicted.
+func (uc *userCache) managedPut(key [sha256.Size]byte, sub string, err error) {
+	if uc.staticMaxSize == 0 {
+		return
+	}
+	ttl := uc.staticTTL
+	if err != nil {
+		if !isInvalidCredentialsError(err) {
+			return
+		}
+		ttl = uc.staticNegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	entry := &userCacheEntry{
+		key:     key,
+		sub:     sub,
+		err:     err,
+		expires: time.Now().Add(ttl),
+	}
+
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	if e, exists := uc.entries[key]; exists {
+		e.Value = entry
+		uc.lru.MoveToFront(e)
+		return
+	}
+	uc.entries[key] = uc.lru.PushFront(entry)
+	for uc.lru.Len() > uc.staticMaxSize {
+		oldest := uc.lru.Back()
+		uc.lru.Remove(oldest)
+		delete(uc.entries, oldest.Value.(*userCacheEntry).key)
+	}
+}
+
+// managedStats returns statistics about the cache.
+func (uc *userCache) managedStats() AccountsCacheStats {
+	uc.mu.Lock()
+	defer uc.mu.Unlock()
+	stats := AccountsCacheStats{
+		Hits:   uc.hits,
+		Misses: uc.misses,
+		Size:   uc.lru.Len(),
+	}
+	if total := uc.hits + uc.misses; total > 0 {
+		stats.HitRate = float64(uc.hits) / float64(total)
+	}
+	return stats
+}
@@ -0,0 +1,144 @@
+package promoter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SkynetLabs/siacoin-promoter/client"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestUserCache is a unit test for the userCache.
+func TestUserCache(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	uc := newUserCache(2, time.Hour, time.Hour)
+	key1 := userCacheKey("auth1", "cookie1")
+	key2 := userCacheKey("auth2", "cookie2")
+	key3 := userCacheKey("auth3", "cookie3")
+
+	// Keys should be different for different credentials.
+	if key1 == key2 || key1 != userCacheKey("auth1", "cookie1") {
+		t.Fatal("bad keys")
+	}
+	if userCacheKey("a", "bc") == userCacheKey("ab", "c") {
+		t.Fatal("keys shouldn't collide")
+	}
+
+	// Empty cache.
+	if _, ok := uc.managedGet(key1); ok {
+		t.Fatal("cache should be empty")
+	}
+
+	// Add 2 entries.
+	uc.managedPut(key1, "sub1", nil)
+	uc.managedPut(key2, "sub2", nil)
+	if e, ok := uc.managedGet(key1); !ok || e.sub != "sub1" {
+		t.Fatal("wrong entry", e, ok)
+	}
+
+	// Adding a third one evicts key2 since key1 was used more recently.
+	uc.managedPut(key3, "sub3", nil)
+	if _, ok := uc.managedGet(key2); ok {
+		t.Fatal("key2 should have been evicted")
+	}
+	if e, ok := uc.managedGet(key3); !ok || e.sub != "sub3" {
+		t.Fatal("wrong entry", e, ok)
+	}
+
+	// Only errors due to invalid credentials are cached.
+	uc.managedPut(key2, "", errors.New("network error"))
+	if _, ok := uc.managedGet(key2); ok {
+		t.Fatal("error shouldn't be cached")
+	}
+	errInvalid := client.Error{Message: "invalid", StatusCode: http.StatusUnauthorized}
+	uc.managedPut(key2, "", errInvalid)
+	if e, ok := uc.managedGet(key2); !ok || e.err != errInvalid {
+		t.Fatal("error should be cached", e, ok)
+	}
+
+	// Check the stats. We had 3 hits and 3 misses.
+	stats := uc.managedStats()
+	if stats.Hits != 3 || stats.Misses != 3 || stats.Size != 2 {
+		t.Fatal("wrong stats", stats)
+	}
+
+	// Expired entries are not returned.
+	uc = newUserCache(2, time.Millisecond, time.Millisecond)
+	uc.managedPut(key1, "sub1", nil)
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := uc.managedGet(key1); ok {
+		t.Fatal("entry should have expired")
+	}
+	if stats := uc.managedStats(); stats.Size != 0 {
+		t.Fatal("expired entry should be removed", stats.Size)
+	}
+
+	// A cache with size 0 doesn't cache anything.
+	uc = newUserCache(0, time.Hour, time.Hour)
+	uc.managedPut(key1, "sub1", nil)
+	if _, ok := uc.managedGet(key1); ok {
+		t.Fatal("cache should be disabled")
+	}
+}
+
+// TestAccountsClientCache makes sure that the AccountsClient only contacts the
+// accounts service for credentials it hasn't seen before.
+func TestAccountsClientCache(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	var requests uint64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddUint64(&requests, 1)
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(AccountsUserGET{
+			Sub: r.Header.Get("Authorization"),
+		})
+	}))
+	defer srv.Close()
+	ac := NewAccountsClient(srv.URL)
+
+	// Fetch the same user twice.
+	headers := http.Header{}
+	headers.Set("Authorization", "foo")
+	for i := 0; i < 2; i++ {
+		sub, err := ac.UserSub(context.Background(), headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub != "foo" {
+			t.Fatal("wrong sub", sub)
+		}
+	}
+	if n := atomic.LoadUint64(&requests); n != 1 {
+		t.Fatal("expected 1 request but got", n)
+	}
+
+	// Fetch a user with invalid credentials twice.
+	for i := 0; i < 2; i++ {
+		_, err := ac.UserSub(context.Background(), http.Header{})
+		if !isInvalidCredentialsError(err) {
+			t.Fatal("expected invalid credentials", err)
+		}
+	}
+	if n := atomic.LoadUint64(&requests); n != 2 {
+		t.Fatal("expected 2 requests but got", n)
+	}
+	if stats := ac.CacheStats(); stats.Hits != 2 || stats.Misses != 2 || stats.HitRate != 0.5 {
+		t.Fatal("wrong stats", stats)
+	}
+}
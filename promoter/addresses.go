@@ -0,0 +1,174 @@
+package promoter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.sia.tech/siad/types"
+)
+
+type (
+	// UserAddress describes an address that was assigned to a user at some
+	// point together with the funds it received.
+	UserAddress struct {
+		Address types.UnlockHash `json:"address"`
+		Primary bool             `json:"primary"`
+		Server  string           `json:"server"`
+
+		// Archived indicates that the address was retired and is no longer
+		// watched.
+		Archived bool `json:"archived"`
+
+		// AssignedAt is the time the address was assigned to the user.
+		// It is the zero time if unknown.
+		AssignedAt time.Time `json:"assignedat"`
+
+		// DemotedAt is the time the address stopped being the user's
+		// primary address. It is the zero time for the primary address
+		// or if unknown.
+		DemotedAt time.Time `json:"demotedat"`
+
+		// Received is the total amount of siacoins received by the
+		// address.
+		Received types.Currency `json:"received"`
+	}
+)
+
+// UserAddresses returns all addresses that were ever assigned to a user. The
+// primary address comes first, followed by the remaining addresses starting
+// with the most recently assigned one.
+func (p *Promoter) UserAddresses(ctx context.Context, sub string) ([]UserAddress, error) {
+	// Fetch the addresses.
+	c, err := p.staticColWatchedAddresses().Find(ctx, bson.M{
+		"user_id": sub,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var was []WatchedAddress
+	if err := c.All(ctx, &was); err != nil {
+		return nil, err
+	}
+
+	// Fetch the retired addresses.
+	c, err = p.staticColArchivedAddresses().Find(ctx, bson.M{
+		"user_id": sub,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var aas []ArchivedAddress
+	if err := c.All(ctx, &aas); err != nil {
+		return nil, err
+	}
+	if len(was)+len(aas) == 0 {
+		return []UserAddress{}, nil
+	}
+	addrs := make([]UserAddress, 0, len(was)+len(aas))
+	addrIDs := make(bson.A, 0, len(was)+len(aas))
+	missingAssignedAt := false
+	addAddress := func(wa WatchedAddress, archived bool) {
+		addrs = append(addrs, UserAddress{
+			Address:    wa.Address,
+			Primary:    wa.Primary,
+			Server:     wa.Server,
+			Archived:   archived,
+			AssignedAt: wa.AssignedAt,
+			DemotedAt:  wa.DemotedAt,
+		})
+		addrIDs = append(addrIDs, wa.Address)
+		missingAssignedAt = missingAssignedAt || wa.AssignedAt.IsZero()
+	}
+	for _, wa := range was {
+		addAddress(wa, false)
+	}
+	for _, aa := range aas {
+		addAddress(aa.WatchedAddress, true)
+	}
+
+	// Sum up the received funds.
+	received, err := p.staticReceivedByAddress(ctx, addrIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range addrs {
+		addrs[i].Received = received[addrs[i].Address]
+	}
+
+	// Addresses assigned before the assignment time was stored in the
+	// address itself fall back to the audit log.
+	if missingAssignedAt {
+		assignedAt, err := p.staticAssignmentTimes(ctx, sub)
+		if err != nil {
+			return nil, err
+		}
+		for i := range addrs {
+			if addrs[i].AssignedAt.IsZero() {
+				addrs[i].AssignedAt = assignedAt[addrs[i].Address]
+			}
+		}
+	}
+
+	// Sort them.
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if addrs[i].Primary != addrs[j].Primary {
+			return addrs[i].Primary
+		}
+		return addrs[i].AssignedAt.After(addrs[j].AssignedAt)
+	})
+	return addrs, nil
+}
+
+// staticReceivedByAddress returns the sum of all transactions sent to the given
+// addresses.
+func (p *Promoter) staticReceivedByAddress(ctx context.Context, addrs bson.A) (map[types.UnlockHash]types.Currency, error) {
+	c, err := p.staticColTransactions().Find(ctx, bson.M{
+		"address_id": bson.M{"$in": addrs},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var txns []Transaction
+	if err := c.All(ctx, &txns); err != nil {
+		return nil, err
+	}
+	received := make(map[types.UnlockHash]types.Currency)
+	for _, txn := range txns {
+		var amt types.Currency
+		if _, err := fmt.Sscan(txn.Value, &amt); err != nil {
+			p.staticLogger.WithError(err).WithField("txn", txn.TxnID).Error("Failed to parse txn amount")
+			continue
+		}
+		received[txn.Address] = received[txn.Address].Add(amt)
+	}
+	return received, nil
+}
+
+// staticAssignmentTimes returns the times at which addresses were assigned to
+// the user according to the audit log.
+func (p *Promoter) staticAssignmentTimes(ctx context.Context, sub string) (map[types.UnlockHash]time.Time, error) {
+	c, err := p.staticColAudit().Find(ctx, bson.M{
+		"user_id": sub,
+		"action":  AuditActionAssignAddress,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	if err := c.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	times := make(map[types.UnlockHash]time.Time, len(entries))
+	for _, entry := range entries {
+		if entry.After == nil {
+			continue
+		}
+		for _, wa := range entry.After.Addresses {
+			times[wa.Address] = entry.Time
+		}
+	}
+	return times, nil
+}
@@ -0,0 +1,94 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+
+	"go.sia.tech/siad/types"
+)
+
+// TestUserAddresses is a unit test for UserAddresses.
+func TestUserAddresses(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// A user without addresses.
+	user := "user"
+	addrs, err := p.UserAddresses(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 0 {
+		t.Fatal("expected no addresses", addrs)
+	}
+
+	// Add 2 addresses to the pool.
+	var addr1, addr2 types.UnlockHash
+	addr1[0] = 1
+	addr2[0] = 2
+	_, err = p.staticColWatchedAddresses().InsertMany(context.Background(), []interface{}{
+		p.newUnusedWatchedAddress(addr1),
+		p.newUnusedWatchedAddress(addr2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assign one and rotate it.
+	first, err := p.AddressForUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.RotateAddress(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Send 2 txns to the first address and 1 to the second.
+	_, err = p.staticInsertTransactions([]interface{}{
+		Transaction{Address: first, TxnID: types.TransactionID{1}, Value: types.SiacoinPrecision.String()},
+		Transaction{Address: first, TxnID: types.TransactionID{2}, Value: types.SiacoinPrecision.Mul64(2).String()},
+		Transaction{Address: second, TxnID: types.TransactionID{3}, Value: types.SiacoinPrecision.String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fetch the addresses. The primary one should come first.
+	addrs, err = p.UserAddresses(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 {
+		t.Fatal("wrong number of addresses", len(addrs))
+	}
+	if addrs[0].Address != second || !addrs[0].Primary || !addrs[0].Received.Equals(types.SiacoinPrecision) {
+		t.Fatal("wrong primary address", addrs[0])
+	}
+	if addrs[1].Address != first || addrs[1].Primary || !addrs[1].Received.Equals(types.SiacoinPrecision.Mul64(3)) {
+		t.Fatal("wrong old address", addrs[1])
+	}
+	for _, addr := range addrs {
+		if addr.AssignedAt.IsZero() || addr.Server != p.staticServerDomain {
+			t.Fatal("wrong address", addr)
+		}
+	}
+	if addrs[0].AssignedAt.Before(addrs[1].AssignedAt) {
+		t.Fatal("wrong assignment times", addrs[0].AssignedAt, addrs[1].AssignedAt)
+	}
+}
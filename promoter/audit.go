@@ -0,0 +1,174 @@
+package promoter
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	// colAuditName is the name of the append-only collection that records
+	// all state-changing operations.
+	colAuditName = "audit"
+
+	// AuditActionAssignAddress is the action recorded when a user is
+	// assigned an address from the pool.
+	AuditActionAssignAddress = "assign_address"
+
+	// AuditActionMarkServerDead is the action recorded when a server is
+	// marked as dead.
+	AuditActionMarkServerDead = "mark_server_dead"
+
+	// AuditActionInvalidatePrimaryAddress is the action recorded when a
+	// user's primary address is marked as !primary.
+	AuditActionInvalidatePrimaryAddress = "invalidate_primary_address"
+
+	// AuditActionSetConversionRate is the action recorded when the
+	// conversion rate from SC to credits is changed.
+	AuditActionSetConversionRate = "set_conversion_rate"
+
+	// actorSystem is the actor recorded for operations that were not
+	// attributed to an actor.
+	actorSystem = "system"
+
+	// defaultAuditLimit is the number of entries returned by AuditEntries
+	// if no limit is specified.
+	defaultAuditLimit = 100
+
+	// maxAuditLimit is the max number of entries returned by a single call
+	// to AuditEntries.
+	maxAuditLimit = 1000
+)
+
+type (
+	// AuditEntry is a single entry of the audit collection.
+	AuditEntry struct {
+		Time   time.Time `bson:"time" json:"time"`
+		Actor  string    `bson:"actor" json:"actor"`
+		Action string    `bson:"action" json:"action"`
+
+		// Server and UserSub are set if the action affected a specific
+		// server or user. They are used for filtering.
+		Server  string `bson:"server,omitempty" json:"server,omitempty"`
+		UserSub string `bson:"user_id,omitempty" json:"usersub,omitempty"`
+
+		// Params contains the parameters of the action.
+		Params map[string]string `bson:"params,omitempty" json:"params,omitempty"`
+
+		// Before and After are snapshots of the affected documents.
+		Before *AuditSnapshot `bson:"before,omitempty" json:"before,omitempty"`
+		After  *AuditSnapshot `bson:"after,omitempty" json:"after,omitempty"`
+	}
+
+	// AuditSnapshot is a snapshot of the documents affected by an action.
+	AuditSnapshot struct {
+		Addresses      []WatchedAddress      `bson:"addresses,omitempty" json:"addresses,omitempty"`
+		ConversionRate *ConfigConversionRate `bson:"conversion_rate,omitempty" json:"conversionrate,omitempty"`
+		PoolSize       *PoolSize             `bson:"pool_size,omitempty" json:"poolsize,omitempty"`
+	}
+
+	// AuditFilter describes the entries to return from AuditEntries. Zero
+	// values are ignored.
+	AuditFilter struct {
+		From    time.Time
+		To      time.Time
+		UserSub string
+		Server  string
+		Action  string
+		Limit   int64
+	}
+
+	// actorKey is the context key for the actor of an operation.
+	actorKey struct{}
+)
+
+// ContextWithActor returns a context which attributes all operations executed
+// with it to the given actor.
+func ContextWithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor of a context. If no actor was set, the
+// system is the actor.
+func ActorFromContext(ctx context.Context) string {
+	actor, ok := ctx.Value(actorKey{}).(string)
+	if !ok || actor == "" {
+		return actorSystem
+	}
+	return actor
+}
+
+// staticColAudit returns the collection used to store the audit log.
+func (p *Promoter) staticColAudit() *mongo.Collection {
+	return p.staticDB.Collection(colAuditName)
+}
+
+// staticInsertAuditEntry adds an entry to the audit log. It should be called
+// with the same session context as the mutation it records.
+func (p *Promoter) staticInsertAuditEntry(ctx context.Context, entry AuditEntry) error {
+	entry.Time = time.Now().UTC()
+	entry.Actor = ActorFromContext(ctx)
+	_, err := p.staticColAudit().InsertOne(ctx, entry)
+	return err
+}
+
+// managedWithTransaction executes fn within a transaction. That way the audit
+// entry of a mutation is only written if the mutation succeeds and vice versa.
+func (p *Promoter) managedWithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	// Transactions need to read from the primary.
+	opts := options.Transaction().SetReadPreference(readpref.Primary())
+	return p.staticDB.Client().UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		}, opts)
+		return err
+	})
+}
+
+// AuditEntries returns the audit log entries matching the filter, most recent
+// ones first.
+func (p *Promoter) AuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	query := bson.M{}
+	timeRange := bson.M{}
+	if !filter.From.IsZero() {
+		timeRange["$gte"] = filter.From.UTC()
+	}
+	if !filter.To.IsZero() {
+		timeRange["$lte"] = filter.To.UTC()
+	}
+	if len(timeRange) > 0 {
+		query["time"] = timeRange
+	}
+	if filter.UserSub != "" {
+		query["user_id"] = filter.UserSub
+	}
+	if filter.Server != "" {
+		query["server"] = filter.Server
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}}).
+		SetLimit(limit)
+	c, err := p.staticColAudit().Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AuditEntry, 0)
+	if err := c.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
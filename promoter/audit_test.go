@@ -0,0 +1,178 @@
+package promoter
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"go.sia.tech/siad/types"
+)
+
+// TestActorFromContext is a unit test for ContextWithActor and
+// ActorFromContext.
+func TestActorFromContext(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	if actor := ActorFromContext(context.Background()); actor != actorSystem {
+		t.Fatal("wrong actor", actor)
+	}
+	if actor := ActorFromContext(ContextWithActor(context.Background(), "")); actor != actorSystem {
+		t.Fatal("wrong actor", actor)
+	}
+	if actor := ActorFromContext(ContextWithActor(context.Background(), "admin")); actor != "admin" {
+		t.Fatal("wrong actor", actor)
+	}
+}
+
+// TestAuditLog makes sure that all state-changing operations are recorded in
+// the audit log and that the log can be queried.
+func TestAuditLog(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Add an unused address to the pool.
+	var addr types.UnlockHash
+	addr[0] = 1
+	_, err = p.staticColWatchedAddresses().InsertOne(context.Background(), p.newUnusedWatchedAddress(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Assign it to a user.
+	start := time.Now()
+	user := "user"
+	userCtx := ContextWithActor(context.Background(), "user:"+user)
+	assigned, err := p.AddressForUser(userCtx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assigned != addr {
+		t.Fatal("wrong address", assigned)
+	}
+
+	// Invalidate it, mark the server dead and update the conversion rate
+	// as an admin.
+	adminCtx := ContextWithActor(context.Background(), "admin")
+	if err := p.SetPrimaryAddressInvalid(adminCtx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MarkServerDead(adminCtx, p.staticServerDomain); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetConversionRate(adminCtx, big.NewRat(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetConversionRate(adminCtx, big.NewRat(-1, 1)); err == nil {
+		t.Fatal("negative rate should be rejected")
+	}
+	rate, err := p.staticConversionRate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Fatal("wrong rate", rate)
+	}
+
+	// Fetch all entries. The most recent one should come first.
+	entries, err := p.AuditEntries(context.Background(), AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedActions := []string{
+		AuditActionSetConversionRate,
+		AuditActionMarkServerDead,
+		AuditActionInvalidatePrimaryAddress,
+		AuditActionAssignAddress,
+	}
+	if len(entries) != len(expectedActions) {
+		t.Fatalf("expected %v entries but got %v", len(expectedActions), len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Action != expectedActions[i] {
+			t.Fatalf("%v: expected action %v but got %v", i, expectedActions[i], entry.Action)
+		}
+	}
+
+	// Check the assignment.
+	assignment := entries[3]
+	if assignment.Actor != "user:"+user || assignment.UserSub != user || assignment.Server != p.staticServerDomain {
+		t.Fatal("wrong assignment entry", assignment)
+	}
+	if len(assignment.Before.Addresses) != 1 || !assignment.Before.Addresses[0].Unused() {
+		t.Fatal("wrong before snapshot", assignment.Before)
+	}
+	if len(assignment.After.Addresses) != 1 || assignment.After.Addresses[0].UserSub != user || !assignment.After.Addresses[0].Primary {
+		t.Fatal("wrong after snapshot", assignment.After)
+	}
+
+	// Check the invalidation.
+	invalidation := entries[2]
+	if invalidation.Actor != "admin" || invalidation.UserSub != user {
+		t.Fatal("wrong invalidation entry", invalidation)
+	}
+	if len(invalidation.Before.Addresses) != 1 || !invalidation.Before.Addresses[0].Primary {
+		t.Fatal("wrong before snapshot", invalidation.Before)
+	}
+	if len(invalidation.After.Addresses) != 1 || invalidation.After.Addresses[0].Primary {
+		t.Fatal("wrong after snapshot", invalidation.After)
+	}
+
+	// Check the conversion rate change. It wasn't initialised before.
+	rateChange := entries[0]
+	if rateChange.Before != nil || rateChange.After.ConversionRate.Numerator != "2" {
+		t.Fatal("wrong conversion rate entry", rateChange)
+	}
+
+	// Filter by user.
+	entries, err = p.AuditEntries(context.Background(), AuditFilter{UserSub: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("wrong number of entries", len(entries))
+	}
+
+	// Filter by server.
+	entries, err = p.AuditEntries(context.Background(), AuditFilter{Server: p.staticServerDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("wrong number of entries", len(entries))
+	}
+
+	// Filter by time.
+	entries, err = p.AuditEntries(context.Background(), AuditFilter{To: start.Add(-time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatal("wrong number of entries", len(entries))
+	}
+	entries, err = p.AuditEntries(context.Background(), AuditFilter{From: start.Add(-time.Second), Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != AuditActionSetConversionRate {
+		t.Fatal("wrong entries", entries)
+	}
+}
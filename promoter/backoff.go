@@ -0,0 +1,166 @@
+package promoter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/NebulousLabs/fastrand"
+)
+
+const (
+	// backoffAddressWatcher is the name of the backoff used by
+	// threadedAddressWatcher.
+	backoffAddressWatcher = "addresswatcher"
+
+	// backoffCreditTransactions is the name of the backoff used by
+	// threadedCreditTransactions.
+	backoffCreditTransactions = "credittransactions"
+
+	// backoffPollTransactions is the name of the backoff used by
+	// threadedPollTransactions.
+	backoffPollTransactions = "polltransactions"
+
+	// backoffPruneLocks is the name of the backoff used by
+	// threadedPruneLocks.
+	backoffPruneLocks = "prunelocks"
+
+	// backoffRegenerateAddresses is the name of the backoff used by
+	// threadedMaintainAddressPool.
+	backoffRegenerateAddresses = "regenerateaddresses"
+
+	// backoffServerHeartbeat is the name of the backoff used by
+	// threadedServerHeartbeat.
+	backoffServerHeartbeat = "serverheartbeat"
+
+	// backoffRetireAddresses is the name of the backoff used by
+	// threadedRetireAddresses.
+	backoffRetireAddresses = "retireaddresses"
+
+	// backoffRescan is the name of the backoff used by threadedRescan.
+	backoffRescan = "rescan"
+
+	// backoffResync is the name of the backoff used by threadedResync.
+	backoffResync = "resync"
+)
+
+type (
+	// BackoffState describes the retry state of a background loop.
+	BackoffState struct {
+		// Failures is the number of consecutive failures of the loop.
+		// It is reset to 0 after a successful iteration.
+		Failures uint64 `json:"failures"`
+
+		// LastError is the error of the last failed iteration.
+		LastError string `json:"lasterror,omitempty"`
+
+		// NextRetry is the time of the next retry if the loop is
+		// currently backing off.
+		NextRetry time.Time `json:"nextretry,omitempty"`
+	}
+
+	// backoff keeps track of the consecutive failures of a background loop
+	// and computes the delay before the next retry. The delay grows
+	// exponentially with the number of failures up until a cap and is
+	// randomized to avoid multiple promoters retrying in lockstep.
+	backoff struct {
+		staticMinDelay time.Duration
+		staticMaxDelay time.Duration
+		staticJitter   float64
+
+		failures  uint64
+		lastErr   error
+		nextRetry time.Time
+		mu        sync.Mutex
+	}
+)
+
+// newBackoff creates a new backoff from the retry settings of the provided
+// options.
+func newBackoff(opts Options) *backoff {
+	return &backoff{
+		staticMinDelay: opts.RetryMinInterval,
+		staticMaxDelay: opts.RetryMaxInterval,
+		staticJitter:   opts.RetryJitter,
+	}
+}
+
+// managedFailure registers a failed iteration and returns the delay to wait
+// before retrying.
+func (b *backoff) managedFailure(err error) time.Duration {
+	return b.managedFailureAtLeast(err, 0)
+}
+
+// managedFailureAtLeast registers a failed iteration and returns the delay to
+// wait before retrying, which is at least minDelay. Periodic loops use their
+// interval as minDelay to never retry more often than they run on success.
+func (b *backoff) managedFailureAtLeast(err error, minDelay time.Duration) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := b.delay(b.failures)
+	if d < minDelay {
+		d = minDelay
+	}
+	b.failures++
+	b.lastErr = err
+	b.nextRetry = time.Now().UTC().Add(d)
+	return d
+}
+
+// managedSuccess registers a successful iteration which resets the backoff.
+func (b *backoff) managedSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.lastErr = nil
+	b.nextRetry = time.Time{}
+}
+
+// managedState returns the current state of the backoff.
+func (b *backoff) managedState() BackoffState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bs := BackoffState{
+		Failures:  b.failures,
+		NextRetry: b.nextRetry,
+	}
+	if b.lastErr != nil {
+		bs.LastError = b.lastErr.Error()
+	}
+	return bs
+}
+
+// managedWait registers a failed iteration and then blocks until it is time
+// to retry. It returns 'false' if the context is closed before that.
+func (b *backoff) managedWait(ctx context.Context, err error) bool {
+	return sleepContext(ctx, b.managedFailure(err))
+}
+
+// delay returns the jittered delay for the given number of previous failures.
+func (b *backoff) delay(failures uint64) time.Duration {
+	d := b.staticMinDelay
+	for i := uint64(0); i < failures && d < b.staticMaxDelay; i++ {
+		d *= 2
+	}
+	if d > b.staticMaxDelay {
+		d = b.staticMaxDelay
+	}
+	// Subtract a random fraction of up to staticJitter from the delay.
+	if maxJitter := uint64(float64(d) * b.staticJitter); maxJitter > 0 {
+		d -= time.Duration(fastrand.Uint64n(maxJitter))
+	}
+	return d
+}
+
+// sleepContext blocks for the given duration. It returns 'false' if the
+// context is closed before the duration has passed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
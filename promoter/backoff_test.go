@@ -0,0 +1,92 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+)
+
+// TestBackoff is a unit test for the backoff type.
+func TestBackoff(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	opts := Options{
+		RetryMinInterval: time.Second,
+		RetryMaxInterval: 10 * time.Second,
+		RetryJitter:      0,
+	}
+	b := newBackoff(opts)
+
+	// Without jitter the delay should double until the cap is reached.
+	expected := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		10 * time.Second,
+		10 * time.Second,
+	}
+	errFailed := errors.New("failed")
+	for i, e := range expected {
+		if d := b.managedFailure(errFailed); d != e {
+			t.Fatalf("%v: wrong delay %v != %v", i, d, e)
+		}
+	}
+
+	// Check the state.
+	bs := b.managedState()
+	if bs.Failures != uint64(len(expected)) {
+		t.Fatal("wrong number of failures", bs.Failures)
+	}
+	if bs.LastError != errFailed.Error() {
+		t.Fatal("wrong error", bs.LastError)
+	}
+	if bs.NextRetry.IsZero() {
+		t.Fatal("next retry should be set")
+	}
+
+	// A success resets the backoff.
+	b.managedSuccess()
+	if bs := b.managedState(); bs != (BackoffState{}) {
+		t.Fatal("state should be reset", bs)
+	}
+	if d := b.managedFailure(errFailed); d != time.Second {
+		t.Fatal("wrong delay after reset", d)
+	}
+
+	// The delay is never shorter than the given minimum.
+	b.managedSuccess()
+	if d := b.managedFailureAtLeast(errFailed, 5*time.Second); d != 5*time.Second {
+		t.Fatal("delay should be extended to the minimum", d)
+	}
+	if d := b.managedFailureAtLeast(errFailed, time.Second); d != 2*time.Second {
+		t.Fatal("delay above the minimum shouldn't change", d)
+	}
+	if bs := b.managedState(); time.Until(bs.NextRetry) <= time.Second {
+		t.Fatal("next retry should account for the minimum", bs.NextRetry)
+	}
+
+	// With jitter the delay should be within [(1-jitter)*d, d].
+	opts.RetryJitter = 0.5
+	b = newBackoff(opts)
+	for i := 0; i < 100; i++ {
+		d := b.delay(2)
+		if d <= 2*time.Second || d > 4*time.Second {
+			t.Fatal("delay out of bounds", d)
+		}
+	}
+
+	// managedWait should return early if the context is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts.RetryMinInterval = time.Hour
+	opts.RetryMaxInterval = time.Hour
+	if b := newBackoff(opts); b.managedWait(ctx, errFailed) {
+		t.Fatal("should return false")
+	}
+}
@@ -3,11 +3,13 @@ package promoter
 import (
 	"context"
 	"math/big"
+	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	lock "github.com/square/mongo-lock"
 	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
 	"go.sia.tech/siad/build"
 	"go.sia.tech/siad/types"
 
@@ -28,8 +30,31 @@ const (
 	lockTTL             = 300 // seconds
 	lockPruningInterval = 24 * time.Hour
 
-	operationTypeInsert = operationType("insert")
-	operationTypeDelete = operationType("delete")
+	operationTypeInsert       = operationType("insert")
+	operationTypeDelete       = operationType("delete")
+	operationTypeUpdate       = operationType("update")
+	operationTypeReplace      = operationType("replace")
+	operationTypeDrop         = operationType("drop")
+	operationTypeRename       = operationType("rename")
+	operationTypeDropDatabase = operationType("dropDatabase")
+	operationTypeInvalidate   = operationType("invalidate")
+)
+
+const (
+	// watcherActionIgnore means that a change doesn't affect skyd.
+	watcherActionIgnore watcherAction = iota
+
+	// watcherActionApply means that a change is passed to the updateFunc
+	// without requiring a rescan.
+	watcherActionApply
+
+	// watcherActionApplyRescan means that a change is passed to the
+	// updateFunc and requires a rescan.
+	watcherActionApplyRescan
+
+	// watcherActionRestart means that the change stream was invalidated
+	// and needs to be restarted.
+	watcherActionRestart
 )
 
 // filterUnusedAddresses is the filter used by queries interested in the number
@@ -45,23 +70,36 @@ var (
 	// defaultConversionRate from SC to Credits is 1 SC -> 1 Credit.
 	defaultConversionRate = new(big.Rat).SetFrac(big.NewInt(1), types.SiacoinPrecision.Big())
 
-	// minUnusedAddresses is the min number of addresses we want to keep in
-	// the db which are not yet assigned to users. If the number drops below
-	// this, we generate more addresses.
+	// minUnusedAddresses is the default min number of addresses we want to
+	// keep in the db which are not yet assigned to users. If the number
+	// drops below this, we generate more addresses.
 	minUnusedAddresses = build.Select(build.Var{
 		Testing:  int64(5),
 		Dev:      int64(50),
 		Standard: int64(5000),
 	}).(int64)
 
-	// maxUnusedAddresses is the max number of addresses we want to keep in
-	// the db which are not yet assigned to users.
+	// maxUnusedAddresses is the default max number of addresses we want to
+	// keep in the db which are not yet assigned to users.
 	maxUnusedAddresses = build.Select(build.Var{
 		Testing:  int64(10),
 		Dev:      int64(100),
 		Standard: int64(10000),
 	}).(int64)
 
+	// addressGenerationChunkSize is the number of addresses which are
+	// generated before inserting them into the db and renewing the lock
+	// over the watched addresses collection.
+	addressGenerationChunkSize = build.Select(build.Var{
+		Testing:  int64(3),
+		Dev:      int64(50),
+		Standard: int64(500),
+	}).(int64)
+
+	// assignCandidates is the number of oldest unused addresses an
+	// assigned address is randomly picked from.
+	assignCandidates = int64(16)
+
 	// updateMaxBatchSize is the max number of addresses we send to skyd
 	// within a single API request.
 	updateMaxBatchSize = minUnusedAddresses
@@ -76,6 +114,10 @@ type (
 	// watched addresses collection.
 	operationType string
 
+	// watcherAction describes how threadedAddressWatcher reacts to a
+	// change of the watched addresses collection.
+	watcherAction int
+
 	// updateFunc is the type of a function that can be used as a callback
 	// in threadedAddressWatcher. Unused determines whether or not the
 	// 'unsed' flag is set in the API request for new addresses to watch.
@@ -127,9 +169,27 @@ type (
 		// and as a result to which seed.
 		Server string `bson:"server"`
 
+		// WalletFingerprint identifies the seed the address was derived
+		// from. See walletFingerprint for details. Addresses generated
+		// before fingerprints were introduced don't have one.
+		WalletFingerprint string `bson:"wallet_fingerprint,omitempty"`
+
 		// UserSub is the user that the address is assigned to. 0 if the
 		// address is unused.
 		UserSub string `bson:"user_id"`
+
+		// CreatedAt is the time the address was generated.
+		// AssignedAt is the time the address was assigned to a user.
+		// DemotedAt is the time the address stopped being primary.
+		// Addresses created before these fields were introduced don't
+		// have them set, in which case they decode to the zero time.
+		CreatedAt  time.Time `bson:"created_at,omitempty"`
+		AssignedAt time.Time `bson:"assigned_at,omitempty"`
+		DemotedAt  time.Time `bson:"demoted_at,omitempty"`
+
+		// LastReceivedAt is the time we last found a new incoming
+		// transaction for the address.
+		LastReceivedAt time.Time `bson:"last_received_at,omitempty"`
 	}
 
 	// WatchedAddressDBUpdate describes an update to the watched address
@@ -173,6 +233,34 @@ func (u *WatchedAddressDBUpdate) ToUpdate() WatchedAddressUpdate {
 	}
 }
 
+// Action returns how threadedAddressWatcher reacts to the update.
+//   - inserts are applied and require a rescan if the address is used.
+//   - deletes are applied.
+//   - replacements are applied as additions. Since the _id of a document can't
+//     change, the address was watched already and doesn't require a rescan.
+//   - updates are ignored since they can't change the _id and therefore the
+//     address either.
+//   - drops, renames and database drops are ignored since they are always
+//     followed by an invalidate.
+//   - invalidates restart the change stream which also computes a new diff
+//     between skyd and the collection.
+func (u *WatchedAddressDBUpdate) Action() watcherAction {
+	switch u.OperationType {
+	case operationTypeInsert:
+		if u.FullDocument.Unused() {
+			return watcherActionApply
+		}
+		return watcherActionApplyRescan
+	case operationTypeDelete, operationTypeReplace:
+		return watcherActionApply
+	case operationTypeUpdate, operationTypeDrop, operationTypeRename, operationTypeDropDatabase:
+		return watcherActionIgnore
+	case operationTypeInvalidate:
+		return watcherActionRestart
+	}
+	return watcherActionIgnore
+}
+
 // Unused returns whether the watched address is currently not assigned to a
 // user.
 func (w *WatchedAddress) Unused() bool {
@@ -218,28 +306,22 @@ func (p *Promoter) AddressForUser(ctx context.Context, sub string) (types.Unlock
 		return types.UnlockHash{}, err
 	}
 
-	// If there was no address, fetch one from the pool.
-	sr = p.staticColWatchedAddresses().FindOneAndUpdate(ctx, filterUnusedAddresses, bson.M{
-		"$set": bson.M{
-			"user_id": sub,
-			"primary": true,
-		},
+	// If there was no address, fetch one from the pool and record the
+	// assignment in the audit log.
+	err = p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		wa, err = p.managedAssignAddress(sc, sub)
+		return err
 	})
-	err = sr.Decode(&wa)
 	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
 		p.staticLogger.WithError(err).Error("Failed to acquire new address for user")
 		return types.UnlockHash{}, err
 	}
 
-	// Kick off goroutine to check if regenerating the pool is necessary in
-	// both the successful case as well as the ErrNoDocuments case. The
-	// latter should never happen but we still try to handle it by
-	// generating new addresses.
-	p.staticWG.Add(1)
-	go func() {
-		p.threadedRegenerateAddresses()
-		p.staticWG.Done()
-	}()
+	// Signal the pool maintenance worker to check if regenerating the pool
+	// is necessary in both the successful case as well as the
+	// ErrNoDocuments case. The latter should never happen but we still try
+	// to handle it by generating new addresses.
+	p.staticSignalRegenerateAddresses()
 
 	return wa.Address, err
 }
@@ -258,58 +340,304 @@ func (p *Promoter) Close() error {
 
 // MarkServerDead marks all watched addresses for a given server as !primary.
 // All affected users will receive new addresses the next time they request
-// their address.
-func (p *Promoter) MarkServerDead(server string) error {
-	// Delete all addresses for that server which are not in use right now
-	// and mark all the remaining addresses as !primary.
-	// We do that within a single session for it to be ACID.
-	return p.staticDB.Client().UseSession(p.staticBGCtx, func(sc mongo.SessionContext) error {
-		_, err := p.staticColWatchedAddresses().DeleteMany(sc, bson.M{
-			"$or": bson.A{
-				bson.M{"user_id": bson.M{"$exists": false}},
-				bson.M{"user_id": ""},
-			},
-			"server": server,
-		})
-		if err != nil {
-			return err
-		}
-		_, err = p.staticColWatchedAddresses().UpdateMany(sc, bson.M{
-			"server":  server,
-			"primary": true,
-		}, bson.M{
-			"$set": bson.M{
-				"primary": false,
-			},
-		})
+// their address. The server's state is left unchanged, so a server which
+// continues to send heartbeats keeps generating addresses. To stop that, set
+// its state to dead with SetServerState instead.
+func (p *Promoter) MarkServerDead(ctx context.Context, server string) error {
+	return p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		return p.staticMarkServerDead(sc, server)
+	})
+}
+
+// staticMarkServerDead deletes all addresses for a given server which are not
+// in use right now and marks all the remaining addresses as !primary. It
+// should be called within a transaction for it to be ACID.
+func (p *Promoter) staticMarkServerDead(sc mongo.SessionContext, server string) error {
+	// Snapshot the addresses that are about to be demoted.
+	before, err := p.staticPrimaryAddresses(sc, bson.M{"server": server})
+	if err != nil {
+		return err
+	}
+	dr, err := p.staticColWatchedAddresses().DeleteMany(sc, bson.M{
+		"$or": bson.A{
+			bson.M{"user_id": bson.M{"$exists": false}},
+			bson.M{"user_id": ""},
+		},
+		"server": server,
+	})
+	if err != nil {
 		return err
+	}
+	now := time.Now().UTC()
+	_, err = p.staticColWatchedAddresses().UpdateMany(sc, bson.M{
+		"server":  server,
+		"primary": true,
+	}, bson.M{
+		"$set": bson.M{
+			"primary":    false,
+			"demoted_at": now,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return p.staticInsertAuditEntry(sc, AuditEntry{
+		Action: AuditActionMarkServerDead,
+		Server: server,
+		Params: map[string]string{
+			"server":         server,
+			"deleted_unused": strconv.FormatInt(dr.DeletedCount, 10),
+		},
+		Before: &AuditSnapshot{Addresses: before},
+		After:  &AuditSnapshot{Addresses: demoted(before, now)},
 	})
 }
 
 // SetPrimaryAddressInvalid marks the primary address for a user as !primary.
 // The next time AddressForUser is called for that user, a new address will be
 // returned.
-func (p *Promoter) SetPrimaryAddressInvalid(sub string) error {
-	// Set the primary address of a user to !primary. We use UpdateMany here
-	// since a user should only ever have 1 primary address anyway. If
+func (p *Promoter) SetPrimaryAddressInvalid(ctx context.Context, sub string) error {
+	return p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		return p.staticInvalidatePrimaryAddress(sc, sub)
+	})
+}
+
+// RotateAddress replaces the primary address of a user with a new address from
+// the pool and returns the new address. If the pool is empty, the user keeps
+// the old address and mongo.ErrNoDocuments is returned.
+func (p *Promoter) RotateAddress(ctx context.Context, sub string) (types.UnlockHash, error) {
+	var wa WatchedAddress
+	err := p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if err := p.staticInvalidatePrimaryAddress(sc, sub); err != nil {
+			return err
+		}
+		var err error
+		wa, err = p.managedAssignAddress(sc, sub)
+		return err
+	})
+	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+		p.staticLogger.WithError(err).Error("Failed to rotate address for user")
+		return types.UnlockHash{}, err
+	}
+
+	// Check if regenerating the pool is necessary. Just like in
+	// AddressForUser, we also do so if the pool was empty.
+	p.staticSignalRegenerateAddresses()
+
+	return wa.Address, err
+}
+
+// managedAssignAddress assigns an address from the pool to the user and makes
+// it the user's primary address. One of the oldest addresses in the pool is
+// assigned first. The assignment is recorded in the audit log so ctx should be the
+// context of a transaction.
+func (p *Promoter) managedAssignAddress(ctx context.Context, sub string) (WatchedAddress, error) {
+	candidates, err := p.managedPoolCandidates(ctx, p.staticPoolStrategy)
+	if err != nil {
+		return WatchedAddress{}, err
+	}
+	excluded, err := p.staticExcludedServers(ctx)
+	if err != nil {
+		return WatchedAddress{}, errors.AddContext(err, "failed to fetch excluded servers")
+	}
+	isExcluded := make(map[string]struct{}, len(excluded))
+	for _, server := range excluded {
+		isExcluded[server] = struct{}{}
+	}
+	filters := make([]bson.M, 0, len(candidates)+1)
+	for _, server := range candidates {
+		if _, skip := isExcluded[server]; !skip {
+			filters = append(filters, filterUnusedAddressesOf(server))
+		}
+	}
+	filters = append(filters, bson.M{
+		"$or":    filterUnusedAddresses["$or"],
+		"server": bson.M{"$nin": excluded},
+	})
+
+	// Try the candidates in order and fall back to any unused address of a
+	// server that isn't excluded.
+	now := time.Now().UTC()
+	update := bson.M{
+		"$set": bson.M{
+			"user_id":     sub,
+			"primary":     true,
+			"assigned_at": now,
+		},
+	}
+	var before WatchedAddress
+	for _, filter := range filters {
+		before, err = p.staticAssignOldUnusedAddress(ctx, filter, update)
+		if !errors.Contains(err, mongo.ErrNoDocuments) {
+			break
+		}
+	}
+	if err != nil {
+		return WatchedAddress{}, err
+	}
+	wa := before
+	wa.UserSub = sub
+	wa.Primary = true
+	wa.AssignedAt = now
+	err = p.staticInsertAuditEntry(ctx, AuditEntry{
+		Action:  AuditActionAssignAddress,
+		Server:  wa.Server,
+		UserSub: sub,
+		Params: map[string]string{
+			"address": wa.Address.String(),
+		},
+		Before: &AuditSnapshot{Addresses: []WatchedAddress{before}},
+		After:  &AuditSnapshot{Addresses: []WatchedAddress{wa}},
+	})
+	return wa, err
+}
+
+// staticAssignOldUnusedAddress applies the update to a random one of the
+// assignCandidates oldest addresses matching the filter and returns the
+// address before the update. Always picking the oldest address would make
+// concurrent assignments contend for the same document. If there is no
+// matching address, mongo.ErrNoDocuments is returned.
+func (p *Promoter) staticAssignOldUnusedAddress(ctx context.Context, filter, update bson.M) (WatchedAddress, error) {
+	c, err := p.staticColWatchedAddresses().Find(ctx, filter, options.Find().
+		SetSort(bson.M{"created_at": 1}).
+		SetLimit(assignCandidates).
+		SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return WatchedAddress{}, err
+	}
+	var candidates []WatchedAddress
+	if err := c.All(ctx, &candidates); err != nil {
+		return WatchedAddress{}, err
+	}
+	for _, i := range fastrand.Perm(len(candidates)) {
+		var before WatchedAddress
+		err = p.staticColWatchedAddresses().FindOneAndUpdate(ctx, bson.M{
+			"$and": bson.A{filter, bson.M{"_id": candidates[i].Address}},
+		}, update).Decode(&before)
+		if errors.Contains(err, mongo.ErrNoDocuments) {
+			continue // assigned in the meantime
+		}
+		return before, err
+	}
+	return WatchedAddress{}, mongo.ErrNoDocuments
+}
+
+// staticInvalidatePrimaryAddress marks the primary address of a user as
+// !primary. The change is recorded in the audit log so ctx should be the
+// context of a transaction.
+func (p *Promoter) staticInvalidatePrimaryAddress(ctx context.Context, sub string) error {
+	before, err := p.staticPrimaryAddresses(ctx, bson.M{"user_id": sub})
+	if err != nil {
+		return err
+	}
+	// Set the primary address of a user to !primary. We use UpdateMany
+	// here since a user should only ever have 1 primary address anyway. If
 	// that's not the case we compensate this way.
-	_, err := p.staticColWatchedAddresses().UpdateMany(context.Background(), bson.M{
+	now := time.Now().UTC()
+	_, err = p.staticColWatchedAddresses().UpdateMany(ctx, bson.M{
 		"user_id": sub,
 		"primary": true,
 	}, bson.M{
 		"$set": bson.M{
-			"primary": false,
+			"primary":    false,
+			"demoted_at": now,
 		},
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	return p.staticInsertAuditEntry(ctx, AuditEntry{
+		Action:  AuditActionInvalidatePrimaryAddress,
+		UserSub: sub,
+		Params: map[string]string{
+			"user": sub,
+		},
+		Before: &AuditSnapshot{Addresses: before},
+		After:  &AuditSnapshot{Addresses: demoted(before, now)},
+	})
+}
+
+// SetConversionRate sets the conversion rate from SC to credits.
+func (p *Promoter) SetConversionRate(ctx context.Context, rate *big.Rat) error {
+	if rate.Sign() <= 0 {
+		return errors.New("conversion rate needs to be positive")
+	}
+	after := ConfigConversionRate{
+		Numerator:   rate.Num().String(),
+		Denominator: rate.Denom().String(),
+	}
+	return p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		// Fetch the current rate for the audit log. It might not be
+		// initialised yet.
+		var before *ConfigConversionRate
+		var ccr ConfigConversionRate
+		err := p.staticColConfig().FindOne(sc, bson.M{
+			"_id": configIDConversionRate,
+		}).Decode(&ccr)
+		if err == nil {
+			before = &ccr
+		} else if !errors.Contains(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		_, err = p.staticColConfig().UpdateOne(sc, bson.M{
+			"_id": configIDConversionRate,
+		}, bson.M{
+			"$set": bson.M{
+				"numerator":   after.Numerator,
+				"denominator": after.Denominator,
+			},
+		}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		entry := AuditEntry{
+			Action: AuditActionSetConversionRate,
+			Params: map[string]string{
+				"numerator":   after.Numerator,
+				"denominator": after.Denominator,
+			},
+			After: &AuditSnapshot{ConversionRate: &after},
+		}
+		if before != nil {
+			entry.Before = &AuditSnapshot{ConversionRate: before}
+		}
+		return p.staticInsertAuditEntry(sc, entry)
+	})
+}
+
+// staticPrimaryAddresses returns the primary addresses matching the filter.
+func (p *Promoter) staticPrimaryAddresses(ctx context.Context, filter bson.M) ([]WatchedAddress, error) {
+	filter["primary"] = true
+	c, err := p.staticColWatchedAddresses().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []WatchedAddress
+	if err := c.All(ctx, &addrs); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// demoted returns a copy of the addresses with their primary flag unset and
+// their demotion time set.
+func demoted(addrs []WatchedAddress, demotedAt time.Time) []WatchedAddress {
+	result := make([]WatchedAddress, 0, len(addrs))
+	for _, addr := range addrs {
+		addr.Primary = false
+		addr.DemotedAt = demotedAt
+		result = append(result, addr)
+	}
+	return result
 }
 
 // newUnusedWatchedAddress creates a new WatchedAddress for this promoter that
 // doesnt' have a User assigned yet.
 func (p *Promoter) newUnusedWatchedAddress(addr types.UnlockHash) WatchedAddress {
 	return WatchedAddress{
-		Address: addr,
-		Server:  p.staticServerDomain,
+		Address:   addr,
+		Server:    p.staticServerDomain,
+		CreatedAt: time.Now().UTC(),
 	}
 }
 
@@ -377,11 +705,15 @@ func (p *Promoter) staticConversionRate() (*big.Rat, error) {
 // actual address generating code should lock the collection, fetch the actual
 // number of addresses and add new ones accordingly.
 func (p *Promoter) staticShouldGenerateAddresses() (bool, error) {
-	n, err := p.staticColWatchedAddresses().CountDocuments(p.staticBGCtx, filterUnusedAddresses, options.Count().SetLimit(minUnusedAddresses))
+	pt, err := p.managedPoolTarget(p.staticBGCtx)
+	if err != nil {
+		return false, err
+	}
+	n, err := p.staticColWatchedAddresses().CountDocuments(p.staticBGCtx, filterUnusedAddressesOf(p.staticServerDomain), options.Count().SetLimit(pt.LowWater))
 	if err != nil {
 		return false, err
 	}
-	return n < minUnusedAddresses, nil
+	return n < pt.LowWater, nil
 }
 
 // staticWatchedDBAddresses returns all watched addresses as seen in the
@@ -405,6 +737,8 @@ func (p *Promoter) staticWatchedDBAddresses(ctx context.Context) ([]WatchedAddre
 // threadedAddressWatcher listens syncs skyd's and the database's watched
 // addresses and then continues listening for changes to the watched addresses.
 func (p *Promoter) threadedAddressWatcher(ctx context.Context, updateFn updateFunc) {
+	b := p.staticBackoffs[backoffAddressWatcher]
+
 	// NOTE: The outter loop is a fallback mechanism in case of an error.
 	// During successful operations it should only do one full iteration.
 OUTER:
@@ -416,11 +750,13 @@ OUTER:
 		}
 
 		// Start watching the collection.
-		stream, err := p.staticColWatchedAddresses().Watch(ctx, mongo.Pipeline{})
+		stream, err := p.staticColWatchedAddresses().Watch(ctx, watchedAddressesPipeline())
 		if err != nil {
 			p.staticLogger.WithError(err).Error("Failed to start watching address collection")
-			time.Sleep(2 * time.Second) // sleep before retrying
-			continue OUTER              // try again
+			if !b.managedWait(ctx, err) {
+				return // shutdown
+			}
+			continue OUTER // try again
 		}
 
 		// Fetch the diff of watched addresses and send updates down the
@@ -428,25 +764,12 @@ OUTER:
 		toAdd, toRemove, err := p.staticAddrDiff(ctx)
 		if err != nil {
 			p.staticLogger.WithError(err).Error("Failed to fetch address diff")
-			time.Sleep(2 * time.Second) // sleep before retrying
-			continue OUTER              // try again
+			if !b.managedWait(ctx, err) {
+				return // shutdown
+			}
+			continue OUTER // try again
 		}
 		toRemoveUpdates := make([]WatchedAddressUpdate, 0, len(toRemove))
-		toAddUpdates := make([]WatchedAddressUpdate, 0, len(toAdd))
-
-		// Track whether any of the addresses to be added is considered
-		// used. If any of them are, we need to call updateFn with
-		// unused = false to make sure we trigger a blockchain rescan in
-		// skyd to pick up on potential transactions from the past.
-		unused := true
-
-		for _, addr := range toAdd {
-			unused = unused && addr.Unused()
-			toAddUpdates = append(toAddUpdates, WatchedAddressUpdate{
-				Address:       addr.Address,
-				OperationType: operationTypeInsert,
-			})
-		}
 		for _, addr := range toRemove {
 			toRemoveUpdates = append(toRemoveUpdates, WatchedAddressUpdate{
 				Address:       addr,
@@ -454,145 +777,279 @@ OUTER:
 			})
 		}
 
-		// To avoid resyncing skyd's wallet too often, we check all
-		// possible edge cases and make sure we only call updateFn with
-		// unused = false once.
-		if len(toAddUpdates) > 0 && len(toRemoveUpdates) == 0 {
-			err = updateFn(unused, toAddUpdates...)
-		} else if len(toAddUpdates) == 0 && len(toRemoveUpdates) > 0 {
-			err = updateFn(false, toRemoveUpdates...)
-		} else if len(toAddUpdates) > 0 && len(toRemoveUpdates) > 0 {
-			err1 := updateFn(true, toRemoveUpdates...)
-			err2 := updateFn(false, toAddUpdates...)
-			err = errors.Compose(err1, err2)
+		// Split the addresses to be added by usedness. Only used ones
+		// need to be passed to updateFn with unused = false to trigger
+		// a blockchain rescan in skyd to pick up on potential
+		// transactions from the past.
+		var unusedAdds, usedAdds []WatchedAddressUpdate
+		for _, addr := range toAdd {
+			update := WatchedAddressUpdate{
+				Address:       addr.Address,
+				OperationType: operationTypeInsert,
+			}
+			if addr.Unused() {
+				unusedAdds = append(unusedAdds, update)
+			} else {
+				usedAdds = append(usedAdds, update)
+			}
 		}
+		err = applyUpdates(updateFn, append(toRemoveUpdates, unusedAdds...), usedAdds)
 		if err != nil {
 			p.staticLogger.WithError(err).Error("Failed to update skyd with initial diff")
-			time.Sleep(2 * time.Second) // sleep before retrying
-			continue OUTER              // try again
+			if !b.managedWait(ctx, err) {
+				return // shutdown
+			}
+			continue OUTER // try again
 		}
+		b.managedSuccess()
 
 		// Start listening for future changes. We block for a change
 		// first and then we check for more changes in a non-blocking
 		// fashion up until a certain batch size. That way we reduce the
 		// number of requests to skyd.
 		for stream.Next(ctx) {
-			var updates []WatchedAddressUpdate
-			unused = true // track if any addresses are used as before.
+			// Split the updates by whether they require a rescan.
+			// Only inserts of used addresses do.
+			var updates, usedInserts []WatchedAddressUpdate
+			restart := false
 			for {
 				// Decode the entry.
 				var wa WatchedAddressDBUpdate
 				if err := stream.Decode(&wa); err != nil {
 					p.staticLogger.WithError(err).Error("Failed to decode watched address")
-					time.Sleep(2 * time.Second) // sleep before retrying
-					continue OUTER              // try again
+					if !b.managedWait(ctx, err) {
+						return // shutdown
+					}
+					continue OUTER // try again
+				}
+				update := wa.ToUpdate()
+				switch wa.Action() {
+				case watcherActionApply:
+					updates = append(updates, update)
+				case watcherActionApplyRescan:
+					usedInserts = append(usedInserts, update)
+				case watcherActionRestart:
+					restart = true
+				case watcherActionIgnore:
+					p.staticLogger.WithField("operationType", wa.OperationType).Debug("Ignoring change of watched addresses")
+				}
+				// Since used inserts are applied last, a later
+				// delete of the same address needs to drop the
+				// insert.
+				if update.OperationType == operationTypeDelete {
+					usedInserts = withoutAddress(usedInserts, update.Address)
 				}
-				unused = unused && wa.FullDocument.Unused()
-				updates = append(updates, wa.ToUpdate())
 
 				// Check if there is more. If not, we continue
-				// the blocking loop.
-				if int64(len(updates)) == updateMaxBatchSize || !stream.TryNext(ctx) {
+				// the blocking loop. After an invalidate the
+				// stream is closed so there is no point in
+				// checking.
+				if restart || int64(len(updates)+len(usedInserts)) == updateMaxBatchSize || !stream.TryNext(ctx) {
 					break
 				}
 			}
 			// Apply the updates.
-			if err := updateFn(unused, updates...); err != nil {
+			err = applyUpdates(updateFn, updates, usedInserts)
+			if err != nil {
 				p.staticLogger.WithError(err).Error("Failed to update skyd with incoming change")
-				time.Sleep(2 * time.Second) // sleep before retrying
-				continue OUTER              // try again
+				_ = stream.Close(ctx)
+				if !b.managedWait(ctx, err) {
+					return // shutdown
+				}
+				continue OUTER // try again
+			}
+			b.managedSuccess()
+
+			// Restart the stream if it was invalidated, e.g. because
+			// the collection was dropped or renamed.
+			if restart {
+				p.staticLogger.Warn("Watched addresses change stream was invalidated, restarting")
+				_ = stream.Close(ctx)
+				continue OUTER
 			}
 		}
 	}
 }
 
-// threadedPruneLocks periodically scans the db for prunable locks.
-func (p *Promoter) threadedPruneLocks() {
-	t := time.NewTicker(lockPruningInterval)
-	defer t.Stop()
+// watchedAddressesPipeline returns the pipeline of the change stream over the
+// watched addresses collection. It only lets through the operation types which
+// aren't ignored by WatchedAddressDBUpdate.Action and the fields the watcher
+// decodes. That way updates of fields like primary or user_id don't cause any
+// traffic. Inserts and replacements contain the full document by default, so
+// no lookup is necessary. Deletes don't contain it which is fine since only
+// the document key matters for them.
+func watchedAddressesPipeline() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": bson.A{
+				operationTypeInsert,
+				operationTypeDelete,
+				operationTypeReplace,
+				operationTypeInvalidate,
+			}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"operationType":        1,
+			"documentKey":          1,
+			"fullDocument.user_id": 1,
+		}}},
+	}
+}
 
-	purger := lock.NewPurger(p.staticLockClient)
-	for {
-		select {
-		case <-p.staticBGCtx.Done():
-			return
-		case <-t.C:
+// applyUpdates passes the updates to updateFn. Updates which don't
+// require a rescan are passed with unused = true and the ones that do with
+// unused = false. That way a single used address doesn't cause a rescan for a
+// whole batch of updates.
+func applyUpdates(updateFn updateFunc, noRescan, rescan []WatchedAddressUpdate) error {
+	var err1, err2 error
+	if len(noRescan) > 0 {
+		err1 = updateFn(true, noRescan...)
+	}
+	if len(rescan) > 0 {
+		err2 = updateFn(false, rescan...)
+	}
+	return errors.Compose(err1, err2)
+}
+
+// withoutAddress returns the updates without the ones for the given address.
+func withoutAddress(updates []WatchedAddressUpdate, addr types.UnlockHash) []WatchedAddressUpdate {
+	filtered := updates[:0]
+	for _, update := range updates {
+		if update.Address != addr {
+			filtered = append(filtered, update)
 		}
+	}
+	return filtered
+}
 
+// threadedPruneLocks periodically scans the db for prunable locks.
+func (p *Promoter) threadedPruneLocks() {
+	purger := lock.NewPurger(p.staticLockClient)
+	p.threadedRetryLoop(backoffPruneLocks, lockPruningInterval, func() error {
 		_, err := purger.Purge(p.staticBGCtx)
 		if err != nil {
 			p.staticLogger.WithTime(time.Now().UTC()).WithError(err).Error("Purging locks failed")
 		}
-	}
+		return err
+	})
 }
 
-// threadedRegenerateAddresses checks whether new addresses need to be generated
-// and then generates enough addresses to restore the pool of unused addresses
-// to maxUnusedAddresses.
-func (p *Promoter) threadedRegenerateAddresses() {
+// managedRegenerateAddresses checks whether new addresses need to be generated
+// and then generates enough addresses to restore the server's share of the
+// pool of unused addresses to the current pool target. It should only be called by
+// threadedMaintainAddressPool.
+func (p *Promoter) managedRegenerateAddresses() error {
+	// Draining and dead servers don't generate new addresses.
+	state, err := p.staticServerState(p.staticBGCtx, p.staticServerDomain)
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch server state")
+	}
+	if state != ServerStateActive {
+		return nil
+	}
+
+	// Make sure skyd's wallet is the one the server's addresses were
+	// generated with. Otherwise we would hand out addresses of an
+	// unexpected seed.
+	fingerprint, err := p.managedCheckWalletFingerprint(p.staticBGCtx)
+	if err != nil {
+		return errors.AddContext(err, "failed to verify wallet fingerprint")
+	}
+
 	// Do a fast check first. This is not accurate but might help us to
 	// avoid a write to the db in most cases.
 	shouldGenerate, err := p.staticShouldGenerateAddresses()
 	if err != nil {
-		p.staticLogger.WithError(err).Error("Failed to check whether regenerating the address pool is necessary")
-		return
+		return errors.AddContext(err, "failed to check whether regenerating the address pool is necessary")
 	}
 	if !shouldGenerate {
-		return // nothing to do
+		return nil // nothing to do
 	}
 
 	// Lock the collection.
-	err = p.staticLockClient.XLock(p.staticBGCtx, "watched-addresses", "watched-addresses", lock.LockDetails{
-		Owner: "siacoin-promoter",
-		Host:  p.staticServerDomain,
-		TTL:   lockTTL,
-	})
+	pl, err := p.managedLockPool(p.staticBGCtx)
 	if err == lock.ErrAlreadyLocked {
 		p.staticLogger.Debug("Not generating new addresses because the collection is already locked")
-		return // nothing to do
+		return nil // another promoter is generating addresses
+	}
+	if err != nil {
+		return errors.AddContext(err, "failed to lock watched addresses collection")
 	}
 
 	// Unlock when we are done.
 	defer func() {
-		if _, err := p.staticLockClient.Unlock(p.staticBGCtx, "watched-addresses"); err != nil {
+		if err := p.managedUnlockPool(pl); err != nil {
 			p.staticLogger.WithError(err).Error("Failed to unlock lock over watched addresses collection")
 		}
 	}()
 
 	// Check number of unused addresses.
-	n, err := p.staticColWatchedAddresses().CountDocuments(p.staticBGCtx, filterUnusedAddresses)
+	n, err := p.staticColWatchedAddresses().CountDocuments(pl.staticCtx, filterUnusedAddressesOf(p.staticServerDomain))
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch count of unused addresses for generating new ones")
+	}
+	pt, err := p.managedPoolTarget(pl.staticCtx)
 	if err != nil {
-		p.staticLogger.WithError(err).Error("Failed to fetch count of unused addresses for generating new ones")
-		return
+		return errors.AddContext(err, "failed to compute pool target")
 	}
 
 	// Figure out how many to generate.
-	toGenerate := maxUnusedAddresses - n
+	toGenerate := pt.Target - n
 	if toGenerate <= 0 {
 		p.staticLogger.WithField("toGenerate", toGenerate).Debug("Not generating new addresses because the collection has enough")
-		return // nothing to do
+		return nil // nothing to do
 	}
 
 	p.staticLogger.WithField("toGenerate", toGenerate).Info("Starting to generate new addresses")
 
-	// Generate the new addresses. We have to do this one-by-one since skyd
-	// doesn't have an endpoint for address batch creation.
-	newAddresses := make([]interface{}, 0, toGenerate)
-	for i := int64(0); i < toGenerate; i++ {
-		wag, err := p.staticSkyd.WalletAddressGet()
+	// Generate the new addresses in chunks. Every chunk is inserted right
+	// away so that a failure doesn't discard the addresses generated so
+	// far. The next regeneration will then pick up where we left off.
+	var generated int64
+	for generated < toGenerate {
+		chunkSize := toGenerate - generated
+		if chunkSize > addressGenerationChunkSize {
+			chunkSize = addressGenerationChunkSize
+		}
+		n, err := p.managedGenerateAddressChunk(pl, fingerprint, chunkSize)
+		generated += n
 		if err != nil {
-			p.staticLogger.WithError(err).Error("Failed to fetch new address from skyd")
-			return
+			p.staticLogger.WithField("generated", generated).WithField("toGenerate", toGenerate).Info("Address generation was interrupted")
+			return err
 		}
-		newAddresses = append(newAddresses, p.newUnusedWatchedAddress(wag.Address))
+	}
+	p.staticLogger.WithField("generated", generated).Info("Finished generating new addresses")
+	return nil
+}
+
+// managedGenerateAddressChunk generates up to n new addresses and inserts them
+// into the db. If generating an address fails, the addresses generated up until
+// then are still inserted. If the lock is lost, nothing is inserted. The number
+// of inserted addresses is returned.
+func (p *Promoter) managedGenerateAddressChunk(pl *poolLock, fingerprint string, n int64) (int64, error) {
+	addrs, genErr := p.staticGenerator.Generate(pl.staticCtx, n)
+	if err := pl.abortErr(); err != nil {
+		return 0, err
+	}
+	if len(addrs) == 0 {
+		return 0, genErr
+	}
+	newAddresses := make([]interface{}, 0, len(addrs))
+	for _, addr := range addrs {
+		wa := p.newUnusedWatchedAddress(addr)
+		wa.WalletFingerprint = fingerprint
+		newAddresses = append(newAddresses, wa)
 	}
 
 	// Insert them into the db.
-	_, err = p.staticColWatchedAddresses().InsertMany(p.staticBGCtx, newAddresses)
+	err := p.managedInsertFenced(pl, newAddresses)
+	if errors.Contains(err, errLockLost) {
+		return 0, err
+	}
 	if err != nil {
-		p.staticLogger.WithError(err).Error("Failed to store generated address in db.")
-		return
+		return 0, errors.Compose(genErr, errors.AddContext(err, "failed to store generated addresses in db"))
 	}
+	return int64(len(newAddresses)), genErr
 }
 
 // staticInsertTransactions inserts transactions into the transaction collection
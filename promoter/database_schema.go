@@ -30,6 +30,42 @@ func (p *Promoter) staticCreateIndexes(ctx context.Context) error {
 				Keys:    bson.M{"user_id": 1},
 				Options: options.Index().SetName("user_id"),
 			},
+			{
+				Keys:    bson.M{"created_at": 1},
+				Options: options.Index().SetName("created_at"),
+			},
+			{
+				Keys:    bson.D{{Key: "server", Value: 1}, {Key: "user_id", Value: 1}, {Key: "created_at", Value: 1}},
+				Options: options.Index().SetName("server_user_id_created_at"),
+			},
+			{
+				Keys:    bson.M{"assigned_at": 1},
+				Options: options.Index().SetName("assigned_at").SetSparse(true),
+			},
+			{
+				Keys:    bson.M{"demoted_at": 1},
+				Options: options.Index().SetName("demoted_at").SetSparse(true),
+			},
+		},
+		colArchivedAddressesName: {
+			{
+				Keys:    bson.M{"user_id": 1},
+				Options: options.Index().SetName("user_id"),
+			},
+		},
+		colAuditName: {
+			{
+				Keys:    bson.M{"time": 1},
+				Options: options.Index().SetName("time"),
+			},
+			{
+				Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "time", Value: 1}},
+				Options: options.Index().SetName("user_id_time"),
+			},
+			{
+				Keys:    bson.D{{Key: "server", Value: 1}, {Key: "time", Value: 1}},
+				Options: options.Index().SetName("server_time"),
+			},
 		},
 		colTransactionsName: {
 			{
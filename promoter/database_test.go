@@ -9,11 +9,13 @@ import (
 	"testing"
 	"time"
 
+	lock "github.com/square/mongo-lock"
 	"gitlab.com/NebulousLabs/errors"
 	"gitlab.com/NebulousLabs/fastrand"
 	"gitlab.com/SkynetLabs/skyd/build"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.sia.tech/siad/types"
 )
 
@@ -214,6 +216,201 @@ func TestAddressWatcher(t *testing.T) {
 	}
 }
 
+// TestWatcherAction is a unit test for WatchedAddressDBUpdate.Action.
+func TestWatcherAction(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		operationType operationType
+		used          bool
+		action        watcherAction
+	}{
+		{operationTypeInsert, false, watcherActionApply},
+		{operationTypeInsert, true, watcherActionApplyRescan},
+		{operationTypeDelete, false, watcherActionApply},
+		{operationTypeReplace, false, watcherActionApply},
+		{operationTypeReplace, true, watcherActionApply},
+		{operationTypeUpdate, true, watcherActionIgnore},
+		{operationTypeDrop, false, watcherActionIgnore},
+		{operationTypeRename, false, watcherActionIgnore},
+		{operationTypeDropDatabase, false, watcherActionIgnore},
+		{operationTypeInvalidate, false, watcherActionRestart},
+		{operationType("unknown"), false, watcherActionIgnore},
+	}
+	for _, test := range tests {
+		u := WatchedAddressDBUpdate{OperationType: test.operationType}
+		if test.used {
+			u.FullDocument.UserSub = "user"
+		}
+		if action := u.Action(); action != test.action {
+			t.Errorf("%v (used: %v): expected %v but got %v", test.operationType, test.used, test.action, action)
+		}
+	}
+}
+
+// TestAddressWatcherOperationTypes tests how threadedAddressWatcher handles
+// the different operation types of the change stream.
+func TestAddressWatcherOperationTypes(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	var mu sync.Mutex
+	var calls []WatchedAddressUpdate
+	updateFn := func(unused bool, updates ...WatchedAddressUpdate) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, updates...)
+		return nil
+	}
+	p, node, err := newTestPromoterWithUpdateFunc(t.Name(), t.Name(), "", updateFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx := context.Background()
+
+	// waitFor waits for an update of the given type for the address. The
+	// recorded calls are kept for later assertions.
+	waitFor := func(addr types.UnlockHash, ot operationType) {
+		t.Helper()
+		err := build.Retry(100, 100*time.Millisecond, func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, call := range calls {
+				if call.Address == addr && call.OperationType == ot {
+					return nil
+				}
+			}
+			return fmt.Errorf("no %v for %v", ot, addr)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Insert an address.
+	var addr types.UnlockHash
+	fastrand.Read(addr[:])
+	if err := p.Watch(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(addr, operationTypeInsert)
+
+	// Update it. This shouldn't be passed on. Then replace it which should
+	// be.
+	_, err = p.staticColWatchedAddresses().UpdateOne(ctx, bson.M{"_id": addr}, bson.M{
+		"$set": bson.M{"primary": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.staticColWatchedAddresses().ReplaceOne(ctx, bson.M{"_id": addr}, p.newUnusedWatchedAddress(addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(addr, operationTypeReplace)
+	mu.Lock()
+	for _, call := range calls {
+		if call.Address == addr && call.OperationType == operationTypeUpdate {
+			t.Error("updates shouldn't be passed on")
+		}
+	}
+	mu.Unlock()
+
+	// Drop the collection. This invalidates the stream. After the watcher
+	// restarted, new inserts should still be picked up.
+	if err := p.staticColWatchedAddresses().Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var addr2 types.UnlockHash
+	fastrand.Read(addr2[:])
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		return p.Watch(ctx, addr2)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(addr2, operationTypeInsert)
+}
+
+// TestWatchedAddressesPipeline makes sure that the change stream pipeline
+// filters out updates.
+func TestWatchedAddressesPipeline(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := p.staticColWatchedAddresses().Watch(ctx, watchedAddressesPipeline())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := stream.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Insert an address, assign it to a user and delete it.
+	var addr types.UnlockHash
+	fastrand.Read(addr[:])
+	if err := p.Watch(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.staticColWatchedAddresses().UpdateOne(ctx, bson.M{"_id": addr}, bson.M{
+		"$set": bson.M{"user_id": "user", "primary": true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Unwatch(ctx, addr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the insert and the delete should show up. Events of other
+	// addresses, e.g. the ones generated by the pool maintainer, are
+	// skipped.
+	for _, expected := range []operationType{operationTypeInsert, operationTypeDelete} {
+		var u WatchedAddressDBUpdate
+		for u.DocumentKey.Address != addr {
+			if !stream.Next(ctx) {
+				t.Fatal("missing event", stream.Err())
+			}
+			u = WatchedAddressDBUpdate{}
+			if err := stream.Decode(&u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if u.OperationType != expected {
+			t.Fatalf("expected %v but got %v", expected, u.OperationType)
+		}
+	}
+}
+
 // TestWatchedDBAddresses is a unit test or staticWatchedDBAddresses.
 func TestWatchedDBAddresses(t *testing.T) {
 	if testing.Short() {
@@ -295,6 +492,25 @@ func TestShouldGenerateAddresses(t *testing.T) {
 		}
 	}()
 
+	// Addresses of other servers don't count towards the server's pool.
+	for i := 0; i < int(minUnusedAddresses); i++ {
+		var addr types.UnlockHash
+		fastrand.Read(addr[:])
+		wa := p.newUnusedWatchedAddress(addr)
+		wa.Server = "other"
+		_, err = p.staticColWatchedAddresses().InsertOne(context.Background(), wa)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	shouldGenerate, err := p.staticShouldGenerateAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !shouldGenerate {
+		t.Fatal("should generate new addresses")
+	}
+
 	// Case exactly minUnusedAddresses. We alternate between inserting
 	// addresses with no user field and addresses with a user field set to
 	// the default value to make sure the methods counts both.
@@ -305,14 +521,15 @@ func TestShouldGenerateAddresses(t *testing.T) {
 			_, err = p.staticColWatchedAddresses().InsertOne(context.Background(), p.newUnusedWatchedAddress(addr))
 		} else {
 			_, err = p.staticColWatchedAddresses().InsertOne(context.Background(), bson.M{
-				"_id": addr.String(),
+				"_id":    addr.String(),
+				"server": p.staticServerDomain,
 			})
 		}
 		if err != nil {
 			t.Fatal(err)
 		}
 	}
-	shouldGenerate, err := p.staticShouldGenerateAddresses()
+	shouldGenerate, err = p.staticShouldGenerateAddresses()
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -320,8 +537,8 @@ func TestShouldGenerateAddresses(t *testing.T) {
 		t.Fatal("should not generate new addresses")
 	}
 
-	// Delete any element.
-	_, err = p.staticColWatchedAddresses().DeleteOne(context.Background(), bson.M{})
+	// Delete any element of the server.
+	_, err = p.staticColWatchedAddresses().DeleteOne(context.Background(), bson.M{"server": p.staticServerDomain})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -335,7 +552,7 @@ func TestShouldGenerateAddresses(t *testing.T) {
 }
 
 // TestAddressForUser is a unit test for AddressForUser and
-// threadedRegenerateAddresses.
+// threadedMaintainAddressPool.
 func TestAddressForUser(t *testing.T) {
 	if testing.Short() {
 		t.SkipNow()
@@ -465,7 +682,7 @@ func TestAddressForUser(t *testing.T) {
 	}
 
 	// Set the user's address to !primary.
-	err = p.SetPrimaryAddressInvalid(user)
+	err = p.SetPrimaryAddressInvalid(context.Background(), user)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -480,7 +697,7 @@ func TestAddressForUser(t *testing.T) {
 	}
 
 	// Mark server dead.
-	err = p.MarkServerDead(p.staticServerDomain)
+	err = p.MarkServerDead(context.Background(), p.staticServerDomain)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -694,3 +911,313 @@ func TestConversionRate(t *testing.T) {
 		t.Fatal("doesn't match new value")
 	}
 }
+
+// TestRotateAddress is a unit test for RotateAddress.
+func TestRotateAddress(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Add 2 addresses to the pool.
+	var addr1, addr2 types.UnlockHash
+	addr1[0] = 1
+	addr2[0] = 2
+	_, err = p.staticColWatchedAddresses().InsertMany(context.Background(), []interface{}{
+		p.newUnusedWatchedAddress(addr1),
+		p.newUnusedWatchedAddress(addr2),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotating the address of a user without an address assigns one.
+	user := "user"
+	addr, err := p.RotateAddress(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate again. Should get the other address.
+	rotated, err := p.RotateAddress(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == addr {
+		t.Fatal("address should have changed")
+	}
+	current, err := p.AddressForUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != rotated {
+		t.Fatal("rotated address should be primary", current, rotated)
+	}
+
+	// Wait for the pool to be regenerated. Then drain it and try again.
+	// This should fail and the user should keep the current address.
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		n, err := p.staticColWatchedAddresses().CountDocuments(context.Background(), filterUnusedAddresses)
+		if err != nil {
+			return err
+		}
+		if n != maxUnusedAddresses {
+			return fmt.Errorf("wrong number of unused addresses %v != %v", n, maxUnusedAddresses)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.staticColWatchedAddresses().DeleteMany(context.Background(), filterUnusedAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.RotateAddress(context.Background(), user)
+	if !errors.Contains(err, mongo.ErrNoDocuments) {
+		t.Fatal("expected ErrNoDocuments", err)
+	}
+	current, err = p.AddressForUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != rotated {
+		t.Fatal("address shouldn't have changed", current, rotated)
+	}
+}
+
+// TestWatchedAddressTimestamps makes sure that the timestamps of watched
+// addresses are set correctly and that addresses without timestamps can still
+// be decoded.
+func TestWatchedAddressTimestamps(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	fetch := func(addr types.UnlockHash) WatchedAddress {
+		t.Helper()
+		var wa WatchedAddress
+		err := p.staticColWatchedAddresses().FindOne(context.Background(), bson.M{"_id": addr}).Decode(&wa)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wa
+	}
+
+	// Insert an address the way it was stored before timestamps were
+	// introduced and a newer one.
+	var legacyAddr, newAddr types.UnlockHash
+	legacyAddr[0] = 1
+	newAddr[0] = 2
+	_, err = p.staticColWatchedAddresses().InsertOne(context.Background(), bson.M{
+		"_id":     legacyAddr,
+		"primary": false,
+		"server":  p.staticServerDomain,
+		"user_id": "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.staticColWatchedAddresses().InsertOne(context.Background(), p.newUnusedWatchedAddress(newAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The legacy address decodes with zero timestamps.
+	legacy := fetch(legacyAddr)
+	if !legacy.CreatedAt.IsZero() || !legacy.AssignedAt.IsZero() || !legacy.DemotedAt.IsZero() {
+		t.Fatal("legacy address shouldn't have timestamps", legacy)
+	}
+	if fetch(newAddr).CreatedAt.IsZero() {
+		t.Fatal("new address should have a creation time")
+	}
+
+	// The legacy address is considered the oldest one.
+	var oldest WatchedAddress
+	err = p.staticColWatchedAddresses().FindOne(context.Background(), filterUnusedAddresses, options.FindOne().SetSort(bson.M{"created_at": 1})).Decode(&oldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldest.Address != legacyAddr {
+		t.Fatal("legacy address should be the oldest one")
+	}
+
+	// Assign an address.
+	user := "user"
+	addr, err := p.AddressForUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wa := fetch(addr)
+	if wa.AssignedAt.IsZero() || !wa.DemotedAt.IsZero() {
+		t.Fatal("wrong timestamps after assignment", wa)
+	}
+
+	// Demote it.
+	if err := p.SetPrimaryAddressInvalid(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	wa = fetch(addr)
+	if wa.DemotedAt.IsZero() || wa.DemotedAt.Before(wa.AssignedAt) {
+		t.Fatal("wrong timestamps after demotion", wa)
+	}
+
+	// Assign another one and mark the server dead.
+	addr, err = p.AddressForUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MarkServerDead(context.Background(), p.staticServerDomain); err != nil {
+		t.Fatal(err)
+	}
+	if wa = fetch(addr); wa.DemotedAt.IsZero() {
+		t.Fatal("address should be demoted", wa)
+	}
+}
+
+// TestAssignOldUnusedAddress tests that addresses are assigned from the
+// assignCandidates oldest unused addresses.
+func TestAssignOldUnusedAddress(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx := context.Background()
+
+	// Add addresses of another server, each one newer than the previous.
+	server := "other.server"
+	n := 2 * assignCandidates
+	age := make(map[types.UnlockHash]int64)
+	var addrs []interface{}
+	for i := int64(0); i < n; i++ {
+		var addr types.UnlockHash
+		addr[0], addr[1] = 1, byte(i)
+		wa := p.newUnusedWatchedAddress(addr)
+		wa.Server = server
+		wa.CreatedAt = wa.CreatedAt.Add(time.Duration(i-n) * time.Minute)
+		age[addr] = i
+		addrs = append(addrs, wa)
+	}
+	if _, err := p.staticColWatchedAddresses().InsertMany(ctx, addrs); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every assignment picks one of the oldest remaining addresses.
+	assigned := make(map[types.UnlockHash]struct{})
+	for i := int64(0); i < n; i++ {
+		wa, err := p.staticAssignOldUnusedAddress(ctx, filterUnusedAddressesOf(server), bson.M{
+			"$set": bson.M{"user_id": fmt.Sprint("user", i)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := assigned[wa.Address]; ok {
+			t.Fatal("address assigned twice", wa.Address)
+		}
+		older := int64(0)
+		for addr, a := range age {
+			if _, ok := assigned[addr]; !ok && a < age[wa.Address] {
+				older++
+			}
+		}
+		if older >= assignCandidates {
+			t.Fatal("address isn't one of the oldest", i, older)
+		}
+		assigned[wa.Address] = struct{}{}
+	}
+
+	// There are no unused addresses left.
+	_, err = p.staticAssignOldUnusedAddress(ctx, filterUnusedAddressesOf(server), bson.M{
+		"$set": bson.M{"user_id": "user"},
+	})
+	if !errors.Contains(err, mongo.ErrNoDocuments) {
+		t.Fatal("expected ErrNoDocuments", err)
+	}
+}
+
+// TestRegenerateAddressesPartial makes sure that addresses which were generated
+// before address generation failed are kept.
+func TestRegenerateAddressesPartial(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	deps := newDependencyDisruptOnKeyword("InterruptAddressGeneration")
+	p, node, err := newTestPromoterWithDeps(t.Name(), deps, t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Every regeneration fails after generating a single address but that
+	// address should still be stored.
+	for i := int64(1); i <= 3; i++ {
+		if err := p.managedRegenerateAddresses(); err == nil {
+			t.Fatal("regeneration should fail")
+		}
+		n, err := p.staticColWatchedAddresses().CountDocuments(context.Background(), filterUnusedAddresses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("expected %v addresses but got %v", i, n)
+		}
+	}
+
+	// The lock should have been released.
+	locks, err := p.staticLockClient.Status(context.Background(), lock.Filter{Resource: p.staticPoolLockResource()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 0 {
+		t.Fatal("lock wasn't released", locks)
+	}
+}
@@ -0,0 +1,138 @@
+package promoter
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+// AuditActionResetWalletFingerprint is the action recorded when the wallet
+// fingerprint of a server is reset.
+const AuditActionResetWalletFingerprint = "reset_wallet_fingerprint"
+
+// errWalletMismatch is returned when the wallet of the address generator
+// doesn't own the reference address stored for the server.
+var errWalletMismatch = errors.New("address generator's wallet doesn't match the server's wallet fingerprint")
+
+// addressFingerprint returns the fingerprint of a wallet's reference address.
+func addressFingerprint(addr types.UnlockHash) string {
+	h := crypto.HashObject(addr)
+	return hex.EncodeToString(h[:8])
+}
+
+// walletFingerprint returns the fingerprint of a wallet seed. It is the
+// fingerprint of the first address derived from the seed which identifies the
+// seed without revealing anything about it.
+func walletFingerprint(seed modules.Seed) string {
+	return addressFingerprint(seedAddress(seed, 0))
+}
+
+// managedVerifyWalletFingerprint checks that the address generator's wallet
+// owns the reference address stored for this server. If the server doesn't
+// have one yet, the generator's reference address and its fingerprint are
+// stored. The fingerprint is returned if it matches and errWalletMismatch
+// otherwise.
+func (p *Promoter) managedVerifyWalletFingerprint(ctx context.Context) (string, error) {
+	var server Server
+	err := p.staticColServers().FindOne(ctx, bson.M{"_id": p.staticServerDomain}).Decode(&server)
+	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+		return "", errors.AddContext(err, "failed to fetch wallet fingerprint")
+	}
+
+	// Store the reference address unless there is a fingerprint already.
+	// If another promoter with the same domain stored one concurrently,
+	// the upsert fails with a duplicate key error which we can ignore
+	// since we fetch the server again afterwards anyway.
+	if server.WalletFingerprint == "" {
+		ref, err := p.staticGenerator.ReferenceAddress()
+		if err != nil {
+			return "", err
+		}
+		_, err = p.staticColServers().UpdateOne(ctx, bson.M{
+			"_id": p.staticServerDomain,
+			"$or": bson.A{
+				bson.M{"wallet_fingerprint": bson.M{"$exists": false}},
+				bson.M{"wallet_fingerprint": ""},
+			},
+		}, bson.M{
+			"$set": bson.M{
+				"wallet_address":     ref,
+				"wallet_fingerprint": addressFingerprint(ref),
+			},
+		}, options.Update().SetUpsert(true))
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return "", errors.AddContext(err, "failed to store wallet fingerprint")
+		}
+		err = p.staticColServers().FindOne(ctx, bson.M{"_id": p.staticServerDomain}).Decode(&server)
+		if err != nil {
+			return "", errors.AddContext(err, "failed to fetch wallet fingerprint")
+		}
+	}
+
+	// The fingerprint can only be verified with its reference address.
+	if server.WalletAddress == nil || addressFingerprint(*server.WalletAddress) != server.WalletFingerprint {
+		return "", errors.AddContext(errWalletMismatch, fmt.Sprintf("fingerprint %v has no matching reference address", server.WalletFingerprint))
+	}
+	owns, err := p.staticGenerator.Owns(*server.WalletAddress)
+	if err != nil {
+		return "", errors.AddContext(err, "failed to check reference address")
+	}
+	if !owns {
+		return "", errors.AddContext(errWalletMismatch, fmt.Sprintf("wallet doesn't own reference address %v of fingerprint %v", server.WalletAddress, server.WalletFingerprint))
+	}
+	return server.WalletFingerprint, nil
+}
+
+// ResetWalletFingerprint forgets the wallet fingerprint and reference address
+// of a server without touching its addresses. The next time the server
+// generates addresses, it stores a reference address of its current address
+// generator. This is required
+// when switching a server to a different seed, e.g. when enabling address
+// generation from a dedicated seed. If the server doesn't exist,
+// mongo.ErrNoDocuments is returned.
+func (p *Promoter) ResetWalletFingerprint(ctx context.Context, server string) error {
+	return p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		var before Server
+		err := p.staticColServers().FindOneAndUpdate(sc, bson.M{
+			"_id": server,
+		}, bson.M{
+			"$unset": bson.M{
+				"wallet_address":     "",
+				"wallet_fingerprint": "",
+			},
+		}).Decode(&before)
+		if err != nil {
+			return err
+		}
+		return p.staticInsertAuditEntry(sc, AuditEntry{
+			Action: AuditActionResetWalletFingerprint,
+			Server: server,
+			Params: map[string]string{
+				"server": server,
+				"before": before.WalletFingerprint,
+			},
+		})
+	})
+}
+
+// managedCheckWalletFingerprint verifies the wallet fingerprint and remembers
+// the result for the promoter's health. A mismatch is logged as an error since
+// the server won't generate any addresses until it is resolved.
+func (p *Promoter) managedCheckWalletFingerprint(ctx context.Context) (string, error) {
+	fingerprint, err := p.managedVerifyWalletFingerprint(ctx)
+	p.mu.Lock()
+	p.walletErr = err
+	p.mu.Unlock()
+	if errors.Contains(err, errWalletMismatch) {
+		p.staticLogger.WithError(err).Error("Wallet doesn't match the server's wallet fingerprint, no addresses will be generated until this is resolved")
+	}
+	return fingerprint, err
+}
@@ -0,0 +1,187 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+// TestWalletFingerprint is a unit test for walletFingerprint.
+func TestWalletFingerprint(t *testing.T) {
+	t.Parallel()
+
+	var seed1, seed2 modules.Seed
+	fastrand.Read(seed1[:])
+	fastrand.Read(seed2[:])
+
+	fp1 := walletFingerprint(seed1)
+	if len(fp1) != 16 {
+		t.Fatal("wrong length", len(fp1))
+	}
+	if fp1 != walletFingerprint(seed1) {
+		t.Fatal("fingerprint should be deterministic")
+	}
+	if fp1 == walletFingerprint(seed2) {
+		t.Fatal("fingerprints of different seeds should differ")
+	}
+}
+
+// TestVerifyWalletFingerprint tests that addresses are tagged with the wallet
+// fingerprint and that no addresses are generated on a mismatch.
+func TestVerifyWalletFingerprint(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx := context.Background()
+
+	// The fingerprint was stored on startup.
+	fingerprint, err := p.managedVerifyWalletFingerprint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server Server
+	err = p.staticColServers().FindOne(ctx, bson.M{"_id": p.staticServerDomain}).Decode(&server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.WalletAddress == nil {
+		t.Fatal("reference address wasn't stored")
+	}
+	if fingerprint != addressFingerprint(*server.WalletAddress) {
+		t.Fatal("wrong fingerprint", fingerprint, addressFingerprint(*server.WalletAddress))
+	}
+
+	// skyd owns the reference address but not a random one.
+	owns, err := p.staticGenerator.Owns(*server.WalletAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !owns {
+		t.Fatal("skyd should own the reference address")
+	}
+	var random types.UnlockHash
+	fastrand.Read(random[:])
+	owns, err = p.staticGenerator.Owns(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owns {
+		t.Fatal("skyd shouldn't own a random address")
+	}
+
+	// Generated addresses are tagged with it.
+	if err := p.managedRegenerateAddresses(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.staticColWatchedAddresses().CountDocuments(ctx, bson.M{
+		"server":             p.staticServerDomain,
+		"wallet_fingerprint": fingerprint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("no addresses tagged with the fingerprint")
+	}
+
+	// Pretend the server used a different wallet before.
+	_, err = p.staticColServers().UpdateOne(ctx, bson.M{"_id": p.staticServerDomain}, bson.M{
+		"$set": bson.M{
+			"wallet_address":     random,
+			"wallet_fingerprint": addressFingerprint(random),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.staticColWatchedAddresses().DeleteMany(ctx, filterUnusedAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.managedRegenerateAddresses(); !errors.Contains(err, errWalletMismatch) {
+		t.Fatal("expected mismatch", err)
+	}
+	n, err = p.staticColWatchedAddresses().CountDocuments(ctx, filterUnusedAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("no addresses should be generated on a mismatch", n)
+	}
+	if err := p.Health().Wallet; !errors.Contains(err, errWalletMismatch) {
+		t.Fatal("mismatch should be reported by the health check", err)
+	}
+
+	// A fingerprint without a reference address can't be verified.
+	_, err = p.staticColServers().UpdateOne(ctx, bson.M{"_id": p.staticServerDomain}, bson.M{
+		"$set":   bson.M{"wallet_fingerprint": "foo"},
+		"$unset": bson.M{"wallet_address": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.managedCheckWalletFingerprint(ctx); !errors.Contains(err, errWalletMismatch) {
+		t.Fatal("expected mismatch", err)
+	}
+
+	// Resetting the fingerprint makes the server accept its generator's
+	// seed without touching its addresses.
+	n, err = p.staticColWatchedAddresses().CountDocuments(ctx, bson.M{"server": p.staticServerDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ResetWalletFingerprint(ctx, p.staticServerDomain); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.managedCheckWalletFingerprint(ctx); err != nil {
+		t.Fatal(err)
+	}
+	after, err := p.staticColWatchedAddresses().CountDocuments(ctx, bson.M{"server": p.staticServerDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after != n {
+		t.Fatal("resetting the fingerprint shouldn't touch addresses", n, after)
+	}
+	if err := p.ResetWalletFingerprint(ctx, "unknown"); !errors.Contains(err, mongo.ErrNoDocuments) {
+		t.Fatal("expected ErrNoDocuments", err)
+	}
+
+	// Marking the server dead keeps the fingerprint.
+	var serverBefore Server
+	err = p.staticColServers().FindOne(ctx, bson.M{"_id": p.staticServerDomain}).Decode(&serverBefore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.MarkServerDead(ctx, p.staticServerDomain); err != nil {
+		t.Fatal(err)
+	}
+	var serverAfter Server
+	err = p.staticColServers().FindOne(ctx, bson.M{"_id": p.staticServerDomain}).Decode(&serverAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverAfter.WalletFingerprint == "" || serverAfter.WalletFingerprint != serverBefore.WalletFingerprint {
+		t.Fatal("marking the server dead shouldn't reset the fingerprint", serverBefore.WalletFingerprint, serverAfter.WalletFingerprint)
+	}
+}
@@ -0,0 +1,186 @@
+package promoter
+
+import (
+	"context"
+
+	"github.com/SkynetLabs/siacoin-promoter/dependencies"
+	mnemonics "gitlab.com/NebulousLabs/entropy-mnemonics"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/node/api/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/crypto"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+const (
+	// configIDSeedIndexPrefix is the prefix of the ID of the next unused
+	// index of a seed in the config collection. The prefix is followed by
+	// the seed's fingerprint.
+	configIDSeedIndexPrefix = "seed_index:"
+)
+
+// errAddressSeedIsSkydSeed is returned when the seed for offline address
+// generation is the seed of skyd's wallet.
+var errAddressSeedIsSkydSeed = errors.New("AddressSeed must not be the seed of skyd's wallet")
+
+type (
+	// addressGenerator generates the addresses which are added to the pool
+	// of unused addresses.
+	addressGenerator interface {
+		// ReferenceAddress returns an address of the generator's wallet.
+		// It is stored when a server first registers and identifies the
+		// wallet from then on.
+		ReferenceAddress() (types.UnlockHash, error)
+
+		// Owns returns whether the reference address was handed out by
+		// the generator's wallet.
+		Owns(addr types.UnlockHash) (bool, error)
+
+		// Generate generates up to n new addresses. If an error occurs,
+		// the addresses generated so far are returned together with
+		// the error.
+		Generate(ctx context.Context, n int64) ([]types.UnlockHash, error)
+	}
+
+	// skydAddressGenerator fetches new addresses from skyd's wallet. It
+	// requires a round-trip to skyd for every address. skyd's seed is
+	// never fetched, the wallet is identified by an address it handed out
+	// instead.
+	skydAddressGenerator struct {
+		staticDeps dependencies.Dependencies
+		staticSkyd *client.Client
+	}
+
+	// seedAddressGenerator derives addresses locally from a seed using the
+	// same scheme as skyd's wallet. The next unused index of the seed is
+	// tracked in the config collection which allows for multiple servers to
+	// share a seed without deriving the same address twice.
+	//
+	// NOTE: Sia addresses use ed25519 keys which don't support deriving
+	// public keys without the private key. That's why the generator needs
+	// the seed itself and not just a public key.
+	seedAddressGenerator struct {
+		staticColConfig   *mongo.Collection
+		staticFingerprint string
+		staticReference   types.UnlockHash
+		staticSeed        modules.Seed
+	}
+
+	// seedIndex is the document storing the next unused index of a seed in
+	// the config collection.
+	seedIndex struct {
+		Value uint64 `bson:"value"`
+	}
+)
+
+// seedAddress derives the address with the given index from a seed the same
+// way skyd's wallet does.
+func seedAddress(seed modules.Seed, index uint64) types.UnlockHash {
+	_, pk := crypto.GenerateKeyPairDeterministic(crypto.HashAll(seed, index))
+	return types.UnlockConditions{
+		PublicKeys:         []types.SiaPublicKey{types.Ed25519PublicKey(pk)},
+		SignaturesRequired: 1,
+	}.UnlockHash()
+}
+
+// parseSeed parses a seed in its mnemonic form.
+func parseSeed(s string) (modules.Seed, error) {
+	return modules.StringToSeed(s, mnemonics.English)
+}
+
+// newSeedAddressGenerator creates a new generator for the given seed.
+func newSeedAddressGenerator(colConfig *mongo.Collection, seed modules.Seed) *seedAddressGenerator {
+	return &seedAddressGenerator{
+		staticColConfig:   colConfig,
+		staticFingerprint: walletFingerprint(seed),
+		staticReference:   seedAddress(seed, 0),
+		staticSeed:        seed,
+	}
+}
+
+// ReferenceAddress fetches a new address from skyd.
+func (g *skydAddressGenerator) ReferenceAddress() (types.UnlockHash, error) {
+	wag, err := g.staticSkyd.WalletAddressGet()
+	if err != nil {
+		return types.UnlockHash{}, errors.AddContext(err, "failed to fetch reference address from skyd")
+	}
+	return wag.Address, nil
+}
+
+// Owns returns whether skyd's wallet owns the address. skyd only knows the
+// unlock conditions of its own addresses. Since it doesn't distinguish an
+// unknown address from other errors, the wallet's addresses are only fetched
+// if looking up the unlock conditions fails.
+func (g *skydAddressGenerator) Owns(addr types.UnlockHash) (bool, error) {
+	_, err := g.staticSkyd.WalletUnlockConditionsGet(addr)
+	if err == nil {
+		return true, nil
+	}
+	wag, err := g.staticSkyd.WalletAddressesGet()
+	if err != nil {
+		return false, errors.AddContext(err, "failed to fetch addresses from skyd")
+	}
+	for _, a := range wag.Addresses {
+		if a == addr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Generate fetches up to n new addresses from skyd.
+func (g *skydAddressGenerator) Generate(ctx context.Context, n int64) ([]types.UnlockHash, error) {
+	addrs := make([]types.UnlockHash, 0, n)
+	for i := int64(0); i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return addrs, err
+		}
+		if i > 0 && g.staticDeps.Disrupt("InterruptAddressGeneration") {
+			return addrs, errors.New("address generation interrupted")
+		}
+		wag, err := g.staticSkyd.WalletAddressGet()
+		if err != nil {
+			return addrs, errors.AddContext(err, "failed to fetch new address from skyd")
+		}
+		addrs = append(addrs, wag.Address)
+	}
+	return addrs, nil
+}
+
+// ReferenceAddress returns the first address derived from the generator's
+// seed.
+func (g *seedAddressGenerator) ReferenceAddress() (types.UnlockHash, error) {
+	return g.staticReference, nil
+}
+
+// Owns returns whether the address is the first address derived from the
+// generator's seed. That's the only address ever used as a reference.
+func (g *seedAddressGenerator) Owns(addr types.UnlockHash) (bool, error) {
+	return addr == g.staticReference, nil
+}
+
+// Generate reserves n indices of the seed and derives the corresponding
+// addresses. Reserved indices are never handed out again, even if the
+// addresses end up not being used.
+func (g *seedAddressGenerator) Generate(ctx context.Context, n int64) ([]types.UnlockHash, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var next seedIndex
+	err := g.staticColConfig.FindOneAndUpdate(ctx, bson.M{
+		"_id": configIDSeedIndexPrefix + g.staticFingerprint,
+	}, bson.M{
+		"$inc": bson.M{"value": n},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&next)
+	if err != nil {
+		return nil, errors.AddContext(err, "failed to reserve seed indices")
+	}
+	addrs := make([]types.UnlockHash, 0, n)
+	for index := next.Value - uint64(n); index < next.Value; index++ {
+		addrs = append(addrs, seedAddress(g.staticSeed, index))
+	}
+	return addrs, nil
+}
@@ -0,0 +1,112 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SkynetLabs/siacoin-promoter/dependencies"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.sia.tech/siad/modules"
+	"go.sia.tech/siad/types"
+)
+
+// TestSeedAddressGenerator tests that generators sharing a seed never derive
+// the same address twice.
+func TestSeedAddressGenerator(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var seed modules.Seed
+	fastrand.Read(seed[:])
+	g1 := newSeedAddressGenerator(p.staticColConfig(), seed)
+	g2 := newSeedAddressGenerator(p.staticColConfig(), seed)
+
+	// The reference address is the first address of the seed.
+	ref, err := g1.ReferenceAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addressFingerprint(ref) != walletFingerprint(seed) {
+		t.Fatal("wrong fingerprint", addressFingerprint(ref))
+	}
+	if owns, err := g2.Owns(ref); err != nil || !owns {
+		t.Fatal("generators sharing a seed should own the reference address", owns, err)
+	}
+	if owns, err := g2.Owns(seedAddress(seed, 1)); err != nil || owns {
+		t.Fatal("only the first address is a reference address", owns, err)
+	}
+
+	// Generate addresses with both generators.
+	generated := make(map[types.UnlockHash]struct{})
+	for i, g := range []*seedAddressGenerator{g1, g2, g1} {
+		addrs, err := g.Generate(context.Background(), 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 5 {
+			t.Fatal("wrong number of addresses", i, len(addrs))
+		}
+		for _, addr := range addrs {
+			generated[addr] = struct{}{}
+		}
+	}
+
+	// They should be the first 15 addresses of the seed.
+	if len(generated) != 15 {
+		t.Fatal("addresses were generated twice", len(generated))
+	}
+	for i := uint64(0); i < 15; i++ {
+		if _, ok := generated[seedAddress(seed, i)]; !ok {
+			t.Fatal("missing address with index", i)
+		}
+	}
+}
+
+// TestAddressSeedIsSkydSeed tests that a promoter can't be created with skyd's
+// seed as its AddressSeed.
+func TestAddressSeedIsSkydSeed(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	wsg, err := node.WalletSeedsGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.AddressSeed = wsg.PrimarySeed
+	_, err = newPromoter(context.Background(), dependencies.ProdDependencies, p.staticAccounts, &node.Client, p.staticLogger, p.staticDB.Client(), t.Name(), t.Name(), opts)
+	if !errors.Contains(err, errAddressSeedIsSkydSeed) {
+		t.Fatal("expected errAddressSeedIsSkydSeed", err)
+	}
+}
@@ -0,0 +1,356 @@
+package promoter
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	// Register the hash functions used by the supported algorithms.
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/SkynetLabs/siacoin-promoter/client"
+	"gitlab.com/NebulousLabs/errors"
+)
+
+const (
+	// defaultJWKSPath is the default path of the accounts service's JWKS
+	// endpoint.
+	defaultJWKSPath = "/.well-known/jwks.json"
+
+	// defaultJWKSRefreshInterval is the default interval after which the
+	// JWKS is fetched again.
+	defaultJWKSRefreshInterval = time.Hour
+
+	// jwksMinRefreshInterval is the min interval between two fetches of
+	// the JWKS. It prevents tokens with unknown key ids from causing a
+	// request to the accounts service every time.
+	jwksMinRefreshInterval = time.Minute
+
+	// jwtCookieName is the name of the cookie that contains the portal's
+	// JWT.
+	jwtCookieName = "skynet-jwt"
+)
+
+var (
+	// errJWTExpired is returned if the token's expiry lies in the past.
+	errJWTExpired = errors.New("token expired")
+
+	// errJWTNotYetValid is returned if the token's nbf lies in the future.
+	errJWTNotYetValid = errors.New("token not valid yet")
+
+	// errJWTInvalidSignature is returned if none of the keys could verify
+	// the token's signature.
+	errJWTInvalidSignature = errors.New("invalid token signature")
+
+	// errJWTMissing is returned if the request didn't contain a token.
+	errJWTMissing = errors.New("no token found in request")
+)
+
+type (
+	// jwk is a single JSON Web Key as returned by the JWKS endpoint.
+	jwk struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+
+		// RSA keys.
+		N string `json:"n"`
+		E string `json:"e"`
+
+		// EC keys.
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+
+	// jwks is a JSON Web Key Set.
+	jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	// jwtHeader is the header of a JWT.
+	jwtHeader struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+
+	// jwtClaims are the claims of a JWT we care about.
+	jwtClaims struct {
+		Sub string `json:"sub"`
+		Exp *int64 `json:"exp"`
+		Nbf *int64 `json:"nbf"`
+	}
+
+	// jwtVerifier verifies JWTs issued by the accounts service locally
+	// using the public keys from the service's JWKS endpoint.
+	jwtVerifier struct {
+		staticClient          *client.Client
+		staticJWKSPath        string
+		staticRefreshInterval time.Duration
+
+		keys        map[string]crypto.PublicKey
+		lastFetch   time.Time
+		lastAttempt time.Time
+		mu          sync.Mutex
+	}
+)
+
+// newJWTVerifier creates a new verifier which fetches the JWKS from the given
+// path using the provided client.
+func newJWTVerifier(c *client.Client, jwksPath string, refreshInterval time.Duration) *jwtVerifier {
+	return &jwtVerifier{
+		staticClient:          c,
+		staticJWKSPath:        jwksPath,
+		staticRefreshInterval: refreshInterval,
+	}
+}
+
+// tokenFromHeaders extracts the JWT from either the Authorization header or
+// the portal's JWT cookie.
+func tokenFromHeaders(headers http.Header) (string, error) {
+	if auth := headers.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), nil
+	}
+	req := http.Request{Header: headers}
+	if c, err := req.Cookie(jwtCookieName); err == nil && c.Value != "" {
+		return c.Value, nil
+	}
+	return "", errJWTMissing
+}
+
+// parsePublicKey turns a jwk into a public key.
+func parsePublicKey(k jwk) (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to decode modulus")
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to decode exponent")
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to decode x")
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to decode y")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", k.Kty)
+	}
+}
+
+// verifySignature verifies the signature of a signed message for the given
+// algorithm and key.
+func verifySignature(alg string, key crypto.PublicKey, msg, sig []byte) bool {
+	var h crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		h = crypto.SHA256
+	case "RS384", "ES384":
+		h = crypto.SHA384
+	case "RS512", "ES512":
+		h = crypto.SHA512
+	default:
+		return false
+	}
+	hasher := h.New()
+	_, _ = hasher.Write(msg)
+	digest := hasher.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, h, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		// ECDSA signatures are the concatenation of r and s.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// managedFetchKeys fetches the JWKS from the accounts service and replaces the
+// known keys with it.
+func (v *jwtVerifier) managedFetchKeys(ctx context.Context) error {
+	v.mu.Lock()
+	v.lastAttempt = time.Now()
+	v.mu.Unlock()
+
+	var set jwks
+	if err := v.staticClient.GetJSONCtx(ctx, v.staticJWKSPath, &set); err != nil {
+		return errors.AddContext(err, "failed to fetch JWKS")
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		pk, err := parsePublicKey(k)
+		if err != nil {
+			continue // ignore keys we don't support
+		}
+		keys[k.Kid] = pk
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = keys
+	v.lastFetch = time.Now()
+	return nil
+}
+
+// managedKeys returns the keys matching the key id. An empty key id matches
+// all keys. The JWKS is fetched again if it is outdated or if no key matches
+// the key id, but at most once every jwksMinRefreshInterval. If fetching the
+// JWKS fails, the previously fetched keys continue to be used. That way an
+// outage of the accounts service doesn't cause a fetch for every request.
+func (v *jwtVerifier) managedKeys(ctx context.Context, kid string) ([]crypto.PublicKey, error) {
+	matching := func() []crypto.PublicKey {
+		var keys []crypto.PublicKey
+		for id, k := range v.keys {
+			if kid == "" || id == kid {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+
+	v.mu.Lock()
+	keys := matching()
+	outdated := time.Since(v.lastFetch) > v.staticRefreshInterval
+	canRefetch := time.Since(v.lastAttempt) > jwksMinRefreshInterval
+	v.mu.Unlock()
+
+	// Refresh the keys if they are outdated or if we don't know the key
+	// and didn't try to fetch it recently.
+	if (outdated || len(keys) == 0) && canRefetch {
+		err := v.managedFetchKeys(ctx)
+		if err != nil && len(keys) == 0 {
+			return nil, err
+		}
+		// Fall back to the stale keys if the refresh failed.
+		if err == nil {
+			v.mu.Lock()
+			keys = matching()
+			v.mu.Unlock()
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key found for kid '%v'", kid)
+	}
+	return keys, nil
+}
+
+// managedVerify verifies a JWT and returns the sub of the user it was issued
+// for.
+func (v *jwtVerifier) managedVerify(ctx context.Context, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+
+	// Decode the header.
+	var header jwtHeader
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errors.AddContext(err, "failed to decode header")
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return "", errors.AddContext(err, "failed to unmarshal header")
+	}
+
+	// Verify the signature.
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.AddContext(err, "failed to decode signature")
+	}
+	keys, err := v.managedKeys(ctx, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	msg := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifySignature(header.Alg, key, msg, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return "", errJWTInvalidSignature
+	}
+
+	// Decode and check the claims.
+	var claims jwtClaims
+	b, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.AddContext(err, "failed to decode claims")
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return "", errors.AddContext(err, "failed to unmarshal claims")
+	}
+	now := time.Now().Unix()
+	if claims.Exp == nil || *claims.Exp <= now {
+		return "", errJWTExpired
+	}
+	if claims.Nbf != nil && *claims.Nbf > now {
+		return "", errJWTNotYetValid
+	}
+	if claims.Sub == "" {
+		return "", errors.New("token doesn't contain a sub")
+	}
+	return claims.Sub, nil
+}
+
+// managedSub extracts the JWT from the headers, verifies it and returns the
+// user's sub.
+func (v *jwtVerifier) managedSub(ctx context.Context, headers http.Header) (string, error) {
+	token, err := tokenFromHeaders(headers)
+	if err != nil {
+		return "", err
+	}
+	return v.managedVerify(ctx, token)
+}
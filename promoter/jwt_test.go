@@ -0,0 +1,219 @@
+package promoter
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testJWTSigner signs tokens for tests.
+type testJWTSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+// jwk returns the signer's public key as a jwk.
+func (s testJWTSigner) jwk() jwk {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch pk := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return jwk{Kid: s.kid, Kty: "RSA", N: enc(pk.N.Bytes()), E: enc(big.NewInt(int64(pk.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return jwk{Kid: s.kid, Kty: "EC", Crv: "P-256", X: enc(pk.X.Bytes()), Y: enc(pk.Y.Bytes())}
+	default:
+		panic("unsupported key")
+	}
+}
+
+// sign creates a token with the given claims.
+func (s testJWTSigner) sign(t *testing.T, claims interface{}) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	header, err := json.Marshal(jwtHeader{Alg: s.alg, Kid: s.kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := enc(header) + "." + enc(payload)
+	digest := sha256.Sum256([]byte(msg))
+
+	var sig []byte
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return msg + "." + enc(sig)
+}
+
+// TestAccountsClientJWT makes sure that the AccountsClient verifies tokens
+// locally when local JWT validation is enabled and falls back to the /user
+// endpoint otherwise.
+func TestAccountsClientJWT(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner := testJWTSigner{kid: "rsa", alg: "RS256", key: rsaKey}
+	ecSigner := testJWTSigner{kid: "ec", alg: "ES256", key: ecKey}
+	rotatedSigner := testJWTSigner{kid: "rotated", alg: "ES256", key: otherKey}
+	forgedSigner := testJWTSigner{kid: "ec", alg: "ES256", key: otherKey}
+
+	// The server starts out publishing the rsa and ec keys.
+	var jwksRequests, userRequests, jwksDown uint64
+	var published atomic.Value
+	published.Store(jwks{Keys: []jwk{rsaSigner.jwk(), ecSigner.jwk()}})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case defaultJWKSPath:
+			atomic.AddUint64(&jwksRequests, 1)
+			if atomic.LoadUint64(&jwksDown) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(published.Load())
+		case "/user":
+			atomic.AddUint64(&userRequests, 1)
+			_ = json.NewEncoder(w).Encode(AccountsUserGET{Sub: "remote"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	opts := DefaultAccountsOptions()
+	opts.CacheSize = 0
+	opts.LocalJWT = true
+	ac := NewAccountsClientWithOptions(srv.URL, opts)
+
+	validClaims := func(sub string) jwtClaims {
+		exp := time.Now().Add(time.Hour).Unix()
+		return jwtClaims{Sub: sub, Exp: &exp}
+	}
+	bearer := func(token string) http.Header {
+		h := http.Header{}
+		h.Set("Authorization", "Bearer "+token)
+		return h
+	}
+	cookie := func(token string) http.Header {
+		h := http.Header{}
+		h.Set("Cookie", jwtCookieName+"="+token)
+		return h
+	}
+	assertSub := func(headers http.Header, expectedSub string, expectedUserRequests uint64) {
+		t.Helper()
+		sub, err := ac.UserSub(context.Background(), headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub != expectedSub {
+			t.Fatalf("expected sub %v but got %v", expectedSub, sub)
+		}
+		if n := atomic.LoadUint64(&userRequests); n != expectedUserRequests {
+			t.Fatalf("expected %v user requests but got %v", expectedUserRequests, n)
+		}
+	}
+
+	// Valid tokens are verified locally, no matter if they are sent as a
+	// bearer token or as a cookie.
+	assertSub(bearer(ecSigner.sign(t, validClaims("ec"))), "ec", 0)
+	assertSub(cookie(rsaSigner.sign(t, validClaims("rsa"))), "rsa", 0)
+	if n := atomic.LoadUint64(&jwksRequests); n != 1 {
+		t.Fatal("jwks should have been fetched once", n)
+	}
+
+	// Expired tokens, tokens that aren't valid yet and forged tokens fall
+	// back to the accounts service.
+	expired := validClaims("expired")
+	*expired.Exp = time.Now().Add(-time.Minute).Unix()
+	assertSub(bearer(ecSigner.sign(t, expired)), "remote", 1)
+	notYetValid := validClaims("nbf")
+	nbf := time.Now().Add(time.Hour).Unix()
+	notYetValid.Nbf = &nbf
+	assertSub(bearer(ecSigner.sign(t, notYetValid)), "remote", 2)
+	assertSub(bearer(forgedSigner.sign(t, validClaims("forged"))), "remote", 3)
+
+	// Tokens without an expiry are rejected.
+	assertSub(bearer(ecSigner.sign(t, jwtClaims{Sub: "noexp"})), "remote", 4)
+
+	// Requests without a token fall back too.
+	assertSub(http.Header{}, "remote", 5)
+
+	// A token signed by a new key causes the JWKS to be fetched again once
+	// the min refresh interval passed.
+	published.Store(jwks{Keys: []jwk{rotatedSigner.jwk()}})
+	ac.staticJWT.mu.Lock()
+	ac.staticJWT.lastAttempt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	ac.staticJWT.mu.Unlock()
+	assertSub(bearer(rotatedSigner.sign(t, validClaims("rotated"))), "rotated", 5)
+	if n := atomic.LoadUint64(&jwksRequests); n != 2 {
+		t.Fatal("jwks should have been fetched twice", n)
+	}
+
+	// Unknown keys don't cause another fetch right away.
+	assertSub(bearer(testJWTSigner{kid: "unknown", alg: "ES256", key: otherKey}.sign(t, validClaims("unknown"))), "remote", 6)
+	if n := atomic.LoadUint64(&jwksRequests); n != 2 {
+		t.Fatal("jwks shouldn't have been fetched again", n)
+	}
+
+	// If refreshing outdated keys fails, the stale keys are still used and
+	// the refresh isn't attempted again right away.
+	atomic.StoreUint64(&jwksDown, 1)
+	ac.staticJWT.mu.Lock()
+	ac.staticJWT.lastFetch = time.Now().Add(-2 * ac.staticJWT.staticRefreshInterval)
+	ac.staticJWT.lastAttempt = time.Now().Add(-2 * jwksMinRefreshInterval)
+	ac.staticJWT.mu.Unlock()
+	requestsBefore := atomic.LoadUint64(&jwksRequests)
+	assertSub(bearer(rotatedSigner.sign(t, validClaims("stale"))), "stale", 6)
+	requestsAfter := atomic.LoadUint64(&jwksRequests)
+	if requestsAfter == requestsBefore {
+		t.Fatal("refresh should have been attempted")
+	}
+	assertSub(bearer(rotatedSigner.sign(t, validClaims("stale"))), "stale", 6)
+	if n := atomic.LoadUint64(&jwksRequests); n != requestsAfter {
+		t.Fatal("failed refresh shouldn't be retried right away", n, requestsAfter)
+	}
+
+	// Without local validation, the accounts service is always used.
+	opts.LocalJWT = false
+	ac = NewAccountsClientWithOptions(srv.URL, opts)
+	assertSub(bearer(rotatedSigner.sign(t, validClaims("rotated"))), "remote", 7)
+}
@@ -0,0 +1,192 @@
+package promoter
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	lock "github.com/square/mongo-lock"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/build"
+)
+
+const (
+	// lockResourceWatchedAddresses is the prefix of the resource locked
+	// while generating new addresses for the watched addresses collection.
+	// Every server locks its own share of the pool.
+	lockResourceWatchedAddresses = "watched-addresses"
+
+	// configIDPoolFencingToken is the prefix of the ID of the fencing
+	// token of the lock over the watched addresses collection in the
+	// config collection.
+	configIDPoolFencingToken = "pool_fencing_token"
+)
+
+var (
+	// lockRenewInterval is the interval at which a held lock over the
+	// watched addresses collection is renewed. It needs to be a lot
+	// smaller than lockTTL.
+	lockRenewInterval = build.Select(build.Var{
+		Dev:      30 * time.Second,
+		Standard: time.Minute,
+		Testing:  time.Second,
+	}).(time.Duration)
+
+	// errLockLost is returned when the lock over the watched addresses
+	// collection was lost while generating addresses.
+	errLockLost = errors.New("lost lock over watched addresses collection")
+)
+
+type (
+	// poolLock is an acquired lock over the watched addresses collection.
+	// While it is held, a background thread renews it. If renewing fails,
+	// the lock's context is closed to abort any work relying on it.
+	poolLock struct {
+		// staticLockID uniquely identifies this acquisition of the
+		// lock.
+		staticLockID string
+
+		// staticToken is the fencing token of the lock. It increases
+		// with every acquisition of the lock which allows for detecting
+		// writes of a previous holder.
+		staticToken   int64
+		staticTokenID string
+
+		staticCtx    context.Context
+		staticCancel context.CancelFunc
+		staticDone   chan struct{}
+		staticLost   chan struct{}
+	}
+
+	// fencingToken is the document storing the current fencing token in
+	// the config collection.
+	fencingToken struct {
+		Value int64 `bson:"value"`
+	}
+)
+
+// staticPoolLockResource returns the name of the resource locked while
+// generating addresses for this server.
+func (p *Promoter) staticPoolLockResource() string {
+	return lockResourceWatchedAddresses + ":" + p.staticServerDomain
+}
+
+// managedLockPool acquires the lock over the watched addresses collection,
+// increments the fencing token and starts renewing the lock in the
+// background. If the lock is held by someone else, lock.ErrAlreadyLocked is
+// returned.
+func (p *Promoter) managedLockPool(ctx context.Context) (*poolLock, error) {
+	lockID := fmt.Sprintf("%s-%s", p.staticServerDomain, hex.EncodeToString(fastrand.Bytes(8)))
+	err := p.staticLockClient.XLock(ctx, p.staticPoolLockResource(), lockID, lock.LockDetails{
+		Owner: "siacoin-promoter",
+		Host:  p.staticServerDomain,
+		TTL:   lockTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Increment the fencing token.
+	var token fencingToken
+	tokenID := configIDPoolFencingToken + ":" + p.staticServerDomain
+	err = p.staticColConfig().FindOneAndUpdate(ctx, bson.M{
+		"_id": tokenID,
+	}, bson.M{
+		"$inc": bson.M{"value": int64(1)},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&token)
+	if err != nil {
+		_, unlockErr := p.staticLockClient.Unlock(p.staticBGCtx, lockID)
+		return nil, errors.Compose(errors.AddContext(err, "failed to increment fencing token"), unlockErr)
+	}
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	pl := &poolLock{
+		staticLockID:  lockID,
+		staticToken:   token.Value,
+		staticTokenID: tokenID,
+		staticCtx:     lockCtx,
+		staticCancel:  cancel,
+		staticDone:    make(chan struct{}),
+		staticLost:    make(chan struct{}),
+	}
+	p.staticWG.Add(1)
+	go func() {
+		defer p.staticWG.Done()
+		p.threadedRenewPoolLock(pl)
+	}()
+	return pl, nil
+}
+
+// threadedRenewPoolLock renews the lock until it is released or the promoter
+// is closed. If renewing fails, the lock is considered lost.
+func (p *Promoter) threadedRenewPoolLock(pl *poolLock) {
+	defer close(pl.staticDone)
+	t := time.NewTicker(lockRenewInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-pl.staticCtx.Done():
+			return // released
+		case <-p.staticBGCtx.Done():
+			pl.staticCancel()
+			return // shutdown
+		case <-t.C:
+		}
+		_, err := p.staticLockClient.Renew(pl.staticCtx, pl.staticLockID, lockTTL)
+		if err != nil && pl.staticCtx.Err() == nil {
+			p.staticLogger.WithError(err).Error("Failed to renew lock over watched addresses collection")
+			close(pl.staticLost)
+			pl.staticCancel()
+			return
+		}
+	}
+}
+
+// managedUnlockPool stops renewing the lock and releases it.
+func (p *Promoter) managedUnlockPool(pl *poolLock) error {
+	pl.staticCancel()
+	<-pl.staticDone
+	_, err := p.staticLockClient.Unlock(p.staticBGCtx, pl.staticLockID)
+	return err
+}
+
+// managedInsertFenced inserts the documents into the watched addresses
+// collection if the fencing token of the lock is still the latest one. This
+// prevents a holder whose lock expired from inserting addresses after another
+// promoter acquired the lock.
+func (p *Promoter) managedInsertFenced(pl *poolLock, docs []interface{}) error {
+	return p.managedWithTransaction(pl.staticCtx, func(sc mongo.SessionContext) error {
+		// Updating the token document within the transaction causes a
+		// write conflict with a concurrent acquisition of the lock.
+		res, err := p.staticColConfig().UpdateOne(sc, bson.M{
+			"_id":   pl.staticTokenID,
+			"value": pl.staticToken,
+		}, bson.M{
+			"$set": bson.M{"used_at": time.Now().UTC()},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return errLockLost
+		}
+		_, err = p.staticColWatchedAddresses().InsertMany(sc, docs)
+		return err
+	})
+}
+
+// abortErr returns the reason for aborting work under the lock or 'nil' if
+// the work can continue.
+func (pl *poolLock) abortErr() error {
+	select {
+	case <-pl.staticLost:
+		return errLockLost
+	default:
+	}
+	return pl.staticCtx.Err()
+}
@@ -0,0 +1,126 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	lock "github.com/square/mongo-lock"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/SkynetLabs/skyd/build"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.sia.tech/siad/types"
+)
+
+// TestPoolLock tests renewing, losing and fencing the lock over the watched
+// addresses collection.
+func TestPoolLock(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Acquire the lock.
+	pl1, err := p.managedLockPool(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// It can't be acquired twice.
+	if _, err := p.managedLockPool(context.Background()); err != lock.ErrAlreadyLocked {
+		t.Fatal("expected ErrAlreadyLocked", err)
+	}
+
+	// The lock should be renewed in the background.
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		locks, err := p.staticLockClient.Status(context.Background(), lock.Filter{LockId: pl1.staticLockID})
+		if err != nil {
+			return err
+		}
+		if len(locks) != 1 || locks[0].RenewedAt == nil {
+			return errors.New("lock wasn't renewed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pl1.abortErr(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Release the lock behind the holder's back. The next renewal should
+	// fail and abort.
+	if _, err := p.staticLockClient.Unlock(context.Background(), pl1.staticLockID); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if err := pl1.abortErr(); err != errLockLost {
+			return errors.AddContext(err, "expected errLockLost")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Acquire the lock again. The fencing token should increase.
+	pl2, err := p.managedLockPool(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl2.staticToken != pl1.staticToken+1 {
+		t.Fatal("wrong fencing token", pl1.staticToken, pl2.staticToken)
+	}
+
+	// The previous holder can't insert anymore even if its context is
+	// still open.
+	var addr1, addr2 types.UnlockHash
+	addr1[0] = 1
+	addr2[0] = 2
+	stale := &poolLock{
+		staticToken:   pl1.staticToken,
+		staticTokenID: pl1.staticTokenID,
+		staticCtx:     context.Background(),
+	}
+	err = p.managedInsertFenced(stale, []interface{}{p.newUnusedWatchedAddress(addr1)})
+	if !errors.Contains(err, errLockLost) {
+		t.Fatal("expected errLockLost", err)
+	}
+	err = p.managedInsertFenced(pl2, []interface{}{p.newUnusedWatchedAddress(addr2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := p.staticColWatchedAddresses().CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatal("expected 1 address", n)
+	}
+
+	// Release the lock.
+	if err := p.managedUnlockPool(pl2); err != nil {
+		t.Fatal(err)
+	}
+	pl3, err := p.managedLockPool(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.managedUnlockPool(pl3); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,295 @@
+package promoter
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/build"
+)
+
+const (
+	// configIDPoolSize is the ID of the pool size in the config
+	// collection.
+	configIDPoolSize = "pool_size"
+
+	// AuditActionSetPoolSize is the action recorded when the size of the
+	// pool of unused addresses is changed.
+	AuditActionSetPoolSize = "set_pool_size"
+)
+
+type (
+	// PoolSize describes how many unused addresses every server keeps
+	// around. Once the number of a server's unused addresses drops below
+	// Min, the server refills its pool up to Max.
+	PoolSize struct {
+		Min int64 `bson:"min" json:"min"`
+		Max int64 `bson:"max" json:"max"`
+	}
+
+	// PoolTarget describes the number of unused addresses the promoter
+	// currently aims for. Without a configured coverage, the pool is
+	// refilled to the max pool size once it drops below the min pool size.
+	// Otherwise the target is sized to cover the recent assignment rate
+	// for the configured duration, bounded by the pool size.
+	PoolTarget struct {
+		// AssignmentsPerHour is the rate at which addresses were
+		// assigned recently.
+		AssignmentsPerHour float64 `json:"assignmentsperhour"`
+
+		// LowWater is the number of unused addresses below which the
+		// pool is refilled.
+		LowWater int64 `json:"lowwater"`
+
+		// Target is the number of unused addresses the pool is
+		// refilled to.
+		Target int64 `json:"target"`
+
+		// ComputedAt is the time the target was computed at.
+		ComputedAt time.Time `json:"computedat"`
+	}
+
+	// PoolStatus describes the current state of the pool of unused
+	// addresses.
+	PoolStatus struct {
+		PoolSize
+		Strategy string `json:"strategy"`
+
+		// Unused is the number of unused addresses of this server.
+		Unused int64 `json:"unused"`
+
+		// Servers contains the number of unused addresses of all
+		// servers.
+		Servers map[string]int64 `json:"servers"`
+	}
+)
+
+var (
+	// poolMaintenanceInterval is the interval at which the pool of unused
+	// addresses is checked even if no address was handed out.
+	poolMaintenanceInterval = build.Select(build.Var{
+		Dev:      time.Minute,
+		Standard: 10 * time.Minute,
+		Testing:  time.Minute,
+	}).(time.Duration)
+
+	// assignmentRateWindow is the window over which the assignment rate
+	// is measured for adaptive pool sizing.
+	assignmentRateWindow = build.Select(build.Var{
+		Dev:      10 * time.Minute,
+		Standard: time.Hour,
+		Testing:  time.Minute,
+	}).(time.Duration)
+)
+
+// DefaultPoolSize returns the default size of the pool of unused addresses.
+func DefaultPoolSize() PoolSize {
+	return PoolSize{
+		Min: minUnusedAddresses,
+		Max: maxUnusedAddresses,
+	}
+}
+
+// Validate checks the pool size for invalid values.
+func (ps PoolSize) Validate() error {
+	if ps.Min <= 0 {
+		return errors.New("min pool size must be greater than 0")
+	}
+	if ps.Max < ps.Min {
+		return errors.New("max pool size can't be smaller than min pool size")
+	}
+	return nil
+}
+
+// staticPoolSize returns the pool size from the config collection. If it was
+// never set, the pool size the promoter was started with is returned.
+func (p *Promoter) staticPoolSize(ctx context.Context) (PoolSize, error) {
+	var ps PoolSize
+	err := p.staticColConfig().FindOne(ctx, bson.M{
+		"_id": configIDPoolSize,
+	}).Decode(&ps)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		return p.staticDefaultPoolSize, nil
+	}
+	if err != nil {
+		return PoolSize{}, err
+	}
+	return ps, nil
+}
+
+// PoolStatus returns the configured pool size together with the current
+// number of unused addresses per server.
+func (p *Promoter) PoolStatus(ctx context.Context) (PoolStatus, error) {
+	ps, err := p.staticPoolSize(ctx)
+	if err != nil {
+		return PoolStatus{}, err
+	}
+	servers, err := p.staticUnusedAddressesByServer(ctx)
+	if err != nil {
+		return PoolStatus{}, err
+	}
+	return PoolStatus{
+		PoolSize: ps,
+		Strategy: p.staticPoolStrategy,
+		Unused:   servers[p.staticServerDomain],
+		Servers:  servers,
+	}, nil
+}
+
+// SetPoolSize updates the pool size in the config collection. It takes effect
+// the next time a promoter checks its pool. For this promoter that happens
+// right away.
+func (p *Promoter) SetPoolSize(ctx context.Context, ps PoolSize) error {
+	if err := ps.Validate(); err != nil {
+		return err
+	}
+	err := p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		before, err := p.staticPoolSize(sc)
+		if err != nil {
+			return err
+		}
+		_, err = p.staticColConfig().UpdateOne(sc, bson.M{
+			"_id": configIDPoolSize,
+		}, bson.M{
+			"$set": bson.M{
+				"min": ps.Min,
+				"max": ps.Max,
+			},
+		}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+		return p.staticInsertAuditEntry(sc, AuditEntry{
+			Action: AuditActionSetPoolSize,
+			Params: map[string]string{
+				"min": strconv.FormatInt(ps.Min, 10),
+				"max": strconv.FormatInt(ps.Max, 10),
+			},
+			Before: &AuditSnapshot{PoolSize: &before},
+			After:  &AuditSnapshot{PoolSize: &ps},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	p.staticSignalRegenerateAddresses()
+	return nil
+}
+
+// computePoolTarget computes the pool target for the given pool size from the
+// number of assignments within the window. A coverage of 0 disables adaptive
+// sizing.
+func computePoolTarget(ps PoolSize, coverage time.Duration, assignments int64, window time.Duration) PoolTarget {
+	perHour := float64(assignments) / window.Hours()
+	if coverage == 0 {
+		return PoolTarget{
+			AssignmentsPerHour: perHour,
+			LowWater:           ps.Min,
+			Target:             ps.Max,
+		}
+	}
+	target := int64(math.Ceil(perHour * coverage.Hours()))
+	if target < ps.Min {
+		target = ps.Min
+	}
+	if target > ps.Max {
+		target = ps.Max
+	}
+	// Refill once half the target is used up to avoid generating
+	// addresses for every single assignment, but at the latest once the
+	// pool drops below the min. The low water mark needs to stay below
+	// the target, otherwise a target clamped to the min would trigger a
+	// refill after every assignment.
+	lowWater := target / 2
+	if lowWater > ps.Min {
+		lowWater = ps.Min
+	}
+	return PoolTarget{
+		AssignmentsPerHour: perHour,
+		LowWater:           lowWater,
+		Target:             target,
+	}
+}
+
+// managedPoolTarget computes the current pool target from the configured pool
+// size and the recent assignment rate of this server's addresses. It also
+// remembers the target for the metrics.
+func (p *Promoter) managedPoolTarget(ctx context.Context) (PoolTarget, error) {
+	ps, err := p.staticPoolSize(ctx)
+	if err != nil {
+		return PoolTarget{}, errors.AddContext(err, "failed to fetch pool size")
+	}
+	var assignments int64
+	now := time.Now().UTC()
+	if p.staticPoolCoverage > 0 {
+		assignments, err = p.staticColWatchedAddresses().CountDocuments(ctx, bson.M{
+			"server":      p.staticServerDomain,
+			"assigned_at": bson.M{"$gte": now.Add(-assignmentRateWindow)},
+		})
+		if err != nil {
+			return PoolTarget{}, errors.AddContext(err, "failed to count recent assignments")
+		}
+	}
+	pt := computePoolTarget(ps, p.staticPoolCoverage, assignments, assignmentRateWindow)
+	pt.ComputedAt = now
+
+	p.mu.Lock()
+	p.poolTarget = pt
+	p.mu.Unlock()
+	return pt, nil
+}
+
+// staticSignalRegenerateAddresses notifies the pool maintenance worker that the
+// pool of unused addresses might need to be refilled. It never blocks. Multiple
+// signals that arrive while the worker is busy are coalesced into a single
+// regeneration.
+func (p *Promoter) staticSignalRegenerateAddresses() {
+	select {
+	case p.staticRegenerateChan <- struct{}{}:
+	default:
+	}
+}
+
+// threadedMaintainAddressPool is the only thread that regenerates addresses.
+// It refills the pool whenever it is signaled and at a fixed interval, which
+// guarantees that at most one regeneration is in flight per process.
+func (p *Promoter) threadedMaintainAddressPool() {
+	b := p.staticBackoffs[backoffRegenerateAddresses]
+
+	// Outside of testing we refill the pool on startup. This is not really
+	// necessary but it will prevent the first user ever from getting an
+	// error when trying to fetch an address in production.
+	wait := poolMaintenanceInterval
+	if build.Release != "testing" {
+		wait = 0
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.staticBGCtx.Done():
+			return // shutdown
+		case <-t.C:
+		case <-p.staticRegenerateChan:
+			// Stop the timer since we reset it after the
+			// regeneration.
+			if !t.Stop() {
+				<-t.C
+			}
+		}
+		if err := p.managedRegenerateAddresses(); err != nil {
+			wait = b.managedFailure(err)
+			p.staticLogger.WithError(err).WithField("retryIn", wait).Error("Failed to regenerate addresses")
+		} else {
+			b.managedSuccess()
+			wait = poolMaintenanceInterval
+		}
+		t.Reset(wait)
+	}
+}
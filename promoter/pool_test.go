@@ -0,0 +1,169 @@
+package promoter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gitlab.com/SkynetLabs/skyd/build"
+)
+
+// TestSignalRegenerateAddresses is a unit test for
+// staticSignalRegenerateAddresses.
+func TestSignalRegenerateAddresses(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p := &Promoter{
+		staticRegenerateChan: make(chan struct{}, 1),
+	}
+
+	// Signaling many times without a worker shouldn't block and should
+	// result in a single pending signal.
+	for i := 0; i < 1000; i++ {
+		p.staticSignalRegenerateAddresses()
+	}
+	if len(p.staticRegenerateChan) != 1 {
+		t.Fatal("expected exactly one pending signal", len(p.staticRegenerateChan))
+	}
+	<-p.staticRegenerateChan
+
+	// After consuming the signal, the next one is queued again.
+	p.staticSignalRegenerateAddresses()
+	if len(p.staticRegenerateChan) != 1 {
+		t.Fatal("expected exactly one pending signal", len(p.staticRegenerateChan))
+	}
+}
+
+// TestPoolSizeValidate is a unit test for PoolSize.Validate.
+func TestPoolSizeValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ps    PoolSize
+		valid bool
+	}{
+		{DefaultPoolSize(), true},
+		{PoolSize{Min: 1, Max: 1}, true},
+		{PoolSize{Min: 1, Max: 2}, true},
+		{PoolSize{Min: 0, Max: 1}, false},
+		{PoolSize{Min: -1, Max: 1}, false},
+		{PoolSize{Min: 2, Max: 1}, false},
+	}
+	for i, test := range tests {
+		if err := test.ps.Validate(); (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v but got %v", i, test.valid, err)
+		}
+	}
+}
+
+// TestSetPoolSize makes sure that changing the pool size is picked up by the
+// pool maintenance worker.
+func TestSetPoolSize(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Initially the default is used.
+	status, err := p.PoolStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.PoolSize != DefaultPoolSize() || status.Unused != 0 {
+		t.Fatal("wrong status", status)
+	}
+
+	// Invalid sizes are rejected.
+	if err := p.SetPoolSize(context.Background(), PoolSize{Min: 2, Max: 1}); err == nil {
+		t.Fatal("should fail")
+	}
+
+	// Set a larger size. The pool should be filled up to the new max.
+	ps := PoolSize{Min: maxUnusedAddresses, Max: 2 * maxUnusedAddresses}
+	if err := p.SetPoolSize(context.Background(), ps); err != nil {
+		t.Fatal(err)
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		status, err := p.PoolStatus(context.Background())
+		if err != nil {
+			return err
+		}
+		if status.PoolSize != ps {
+			return fmt.Errorf("wrong pool size %v", status.PoolSize)
+		}
+		if status.Unused != ps.Max {
+			return fmt.Errorf("expected %v unused addresses but got %v", ps.Max, status.Unused)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The change was audited.
+	entries, err := p.AuditEntries(context.Background(), AuditFilter{Action: AuditActionSetPoolSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || *entries[0].Before.PoolSize != DefaultPoolSize() || *entries[0].After.PoolSize != ps {
+		t.Fatal("wrong audit entries", entries)
+	}
+}
+
+// TestComputePoolTarget is a unit test for computePoolTarget.
+func TestComputePoolTarget(t *testing.T) {
+	t.Parallel()
+
+	ps := PoolSize{Min: 10, Max: 100}
+	tests := []struct {
+		coverage    time.Duration
+		assignments int64
+		window      time.Duration
+		perHour     float64
+		lowWater    int64
+		target      int64
+	}{
+		// Adaptive sizing disabled.
+		{0, 50, time.Hour, 50, 10, 100},
+		// No demand, the target is clamped to the min.
+		{6 * time.Hour, 0, time.Hour, 0, 5, 10},
+		// Some demand.
+		{2 * time.Hour, 15, 30 * time.Minute, 30, 10, 60},
+		// Demand exceeding the max.
+		{6 * time.Hour, 50, time.Hour, 50, 10, 100},
+		// Low demand where half the target is below the min.
+		{time.Hour, 15, time.Hour, 15, 7, 15},
+	}
+	for i, test := range tests {
+		pt := computePoolTarget(ps, test.coverage, test.assignments, test.window)
+		if pt.AssignmentsPerHour != test.perHour || pt.LowWater != test.lowWater || pt.Target != test.target {
+			t.Errorf("%v: wrong target %+v", i, pt)
+		}
+	}
+
+	// A target clamped to the min always leaves room for assignments
+	// before the pool is refilled.
+	for m := int64(1); m <= 10; m++ {
+		pt := computePoolTarget(PoolSize{Min: m, Max: 100}, time.Hour, 0, time.Hour)
+		if pt.Target != m || pt.LowWater >= pt.Target {
+			t.Errorf("min %v: low water should be below the target %+v", m, pt)
+		}
+	}
+}
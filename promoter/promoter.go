@@ -19,11 +19,60 @@ import (
 
 type (
 	// Health contains health information about the promoter. Namely the
-	// database and skyd. If everything is ok all fields are 'nil'.
-	// Otherwise the corresponding fields will contain an error.
+	// database and skyd. If everything is ok the error fields are 'nil'.
+	// Otherwise the corresponding fields will contain an error. Wallet
+	// contains the result of the last wallet fingerprint check. Backoffs
+	// contains the retry state of the background loops.
 	Health struct {
 		Database error
 		Skyd     error
+		Wallet   error
+		Backoffs map[string]BackoffState
+	}
+
+	// Metrics contains metrics about the promoter's operation.
+	Metrics struct {
+		AccountsCache AccountsCacheStats
+		PoolTarget    PoolTarget
+	}
+
+	// Options contains the configurable parameters of the promoter.
+	Options struct {
+		// RetryMinInterval is the delay before retrying a failed
+		// background operation for the first time.
+		RetryMinInterval time.Duration
+
+		// RetryMaxInterval is the cap for the exponentially growing
+		// delay between retries.
+		RetryMaxInterval time.Duration
+
+		// RetryJitter is the fraction of the retry delay which is
+		// randomized. Must be within [0, 1].
+		RetryJitter float64
+
+		// RetentionPeriod is the time after which an address that is no
+		// longer primary and didn't receive any funds is retired. 0
+		// disables retiring addresses.
+		RetentionPeriod time.Duration
+
+		// PoolSize is the size of the pool of unused addresses unless
+		// a different size was set in the database.
+		PoolSize PoolSize
+
+		// PoolCoverage enables adaptive pool sizing. If set, the pool
+		// is sized to cover the recent assignment rate for this
+		// duration, bounded by PoolSize.
+		PoolCoverage time.Duration
+
+		// PoolStrategy decides from which server's pool an address is
+		// assigned. See the PoolStrategy constants.
+		PoolStrategy string
+
+		// AddressSeed is an optional seed in its mnemonic form. If set,
+		// addresses are derived from it locally instead of being
+		// fetched from skyd one by one. It should be a dedicated seed
+		// that isn't used by any skyd wallet.
+		AddressSeed string
 	}
 
 	// Promoter is a wrapper around a skyd and a database client. It makes
@@ -45,6 +94,54 @@ type (
 		staticAccounts *AccountsClient
 		staticSkyd     *client.Client
 
+		// staticGenerator generates the addresses for the pool of
+		// unused addresses.
+		staticGenerator addressGenerator
+
+		// staticBackoffs contains the backoffs of the background loops
+		// by name.
+		staticBackoffs map[string]*backoff
+
+		// staticRetentionPeriod is the time after which idle addresses
+		// are retired.
+		staticRetentionPeriod time.Duration
+
+		// staticDefaultPoolSize is the size of the pool of unused
+		// addresses if none was set in the database.
+		staticDefaultPoolSize PoolSize
+
+		// staticPoolCoverage is the duration of demand the pool
+		// should cover.
+		staticPoolCoverage time.Duration
+
+		// staticPoolStrategy is the strategy for picking the server
+		// whose addresses are assigned.
+		staticPoolStrategy string
+
+		// poolTarget is the last computed pool target.
+		poolTarget PoolTarget
+
+		// poolRoundRobin is the counter used by the round-robin pool
+		// strategy.
+		poolRoundRobin uint64
+
+		// walletErr is the result of the last wallet fingerprint
+		// check.
+		walletErr error
+
+		// pendingRescan contains the addresses that are waiting for a
+		// rescan.
+		pendingRescan map[types.UnlockHash]struct{}
+		mu            sync.Mutex
+
+		// staticRegenerateChan is used to signal the pool maintenance
+		// worker that addresses were handed out.
+		staticRegenerateChan chan struct{}
+
+		// staticRescanChan is used to signal the rescan worker that
+		// addresses were queued for a rescan.
+		staticRescanChan chan struct{}
+
 		staticCtx          context.Context
 		staticBGCtx        context.Context
 		staticThreadCancel context.CancelFunc
@@ -61,15 +158,79 @@ var (
 		Standard: 10 * time.Minute,
 		Testing:  5 * time.Second,
 	}).(time.Duration)
+
+	// defaultRetryMinInterval is the default for Options.RetryMinInterval.
+	defaultRetryMinInterval = build.Select(build.Var{
+		Dev:      time.Second,
+		Standard: 2 * time.Second,
+		Testing:  100 * time.Millisecond,
+	}).(time.Duration)
+
+	// defaultRetryMaxInterval is the default for Options.RetryMaxInterval.
+	defaultRetryMaxInterval = build.Select(build.Var{
+		Dev:      time.Minute,
+		Standard: 10 * time.Minute,
+		Testing:  time.Second,
+	}).(time.Duration)
 )
 
+const (
+	// defaultRetryJitter is the default for Options.RetryJitter.
+	defaultRetryJitter = 0.5
+)
+
+// DefaultOptions returns the default options for the promoter.
+func DefaultOptions() Options {
+	return Options{
+		RetryMinInterval: defaultRetryMinInterval,
+		RetryMaxInterval: defaultRetryMaxInterval,
+		RetryJitter:      defaultRetryJitter,
+		PoolSize:         DefaultPoolSize(),
+		PoolStrategy:     PoolStrategyAny,
+	}
+}
+
+// Validate checks the options for invalid values.
+func (o Options) Validate() error {
+	if o.RetryMinInterval <= 0 {
+		return errors.New("RetryMinInterval must be greater than 0")
+	}
+	if o.RetryMaxInterval < o.RetryMinInterval {
+		return errors.New("RetryMaxInterval can't be smaller than RetryMinInterval")
+	}
+	if o.RetryJitter < 0 || o.RetryJitter > 1 {
+		return errors.New("RetryJitter must be within [0, 1]")
+	}
+	if o.RetentionPeriod < 0 {
+		return errors.New("RetentionPeriod can't be negative")
+	}
+	if err := o.PoolSize.Validate(); err != nil {
+		return errors.AddContext(err, "invalid PoolSize")
+	}
+	if o.PoolCoverage < 0 {
+		return errors.New("PoolCoverage can't be negative")
+	}
+	if !validPoolStrategy(o.PoolStrategy) {
+		return fmt.Errorf("unknown PoolStrategy '%v'", o.PoolStrategy)
+	}
+	if o.AddressSeed != "" {
+		if _, err := parseSeed(o.AddressSeed); err != nil {
+			return errors.AddContext(err, "invalid AddressSeed")
+		}
+	}
+	return nil
+}
+
 // New creates a new promoter from the given db credentials.
-func New(ctx context.Context, deps dependencies.Dependencies, ac *AccountsClient, skyd *client.Client, log *logrus.Entry, uri, username, password, domain, db string) (*Promoter, error) {
+func New(ctx context.Context, deps dependencies.Dependencies, ac *AccountsClient, skyd *client.Client, log *logrus.Entry, uri, username, password, domain, db string, opts Options) (*Promoter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, errors.AddContext(err, "invalid options")
+	}
 	client, err := connect(ctx, log, uri, username, password)
 	if err != nil {
 		return nil, err
 	}
-	p, err := newPromoter(ctx, deps, ac, skyd, log, client, domain, db)
+	p, err := newPromoter(ctx, deps, ac, skyd, log, client, domain, db, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +239,7 @@ func New(ctx context.Context, deps dependencies.Dependencies, ac *AccountsClient
 }
 
 // newPromoter creates a new promoter object from a given db client.
-func newPromoter(ctx context.Context, deps dependencies.Dependencies, ac *AccountsClient, skyd *client.Client, log *logrus.Entry, client *mongo.Client, domain, db string) (*Promoter, error) {
+func newPromoter(ctx context.Context, deps dependencies.Dependencies, ac *AccountsClient, skyd *client.Client, log *logrus.Entry, client *mongo.Client, domain, db string, opts Options) (*Promoter, error) {
 	// Grab database from client.
 	database := client.Database(db)
 
@@ -87,50 +248,109 @@ func newPromoter(ctx context.Context, deps dependencies.Dependencies, ac *Accoun
 
 	// Create store.
 	p := &Promoter{
-		staticAccounts:     ac,
-		staticBGCtx:        bgCtx,
-		staticDeps:         deps,
-		staticThreadCancel: cancel,
-		staticCtx:          ctx,
-		staticDB:           database,
-		staticLogger:       log,
-		staticServerDomain: domain,
-		staticSkyd:         skyd,
+		staticAccounts: ac,
+		staticBackoffs: map[string]*backoff{
+			backoffAddressWatcher:      newBackoff(opts),
+			backoffCreditTransactions:  newBackoff(opts),
+			backoffPollTransactions:    newBackoff(opts),
+			backoffPruneLocks:          newBackoff(opts),
+			backoffRegenerateAddresses: newBackoff(opts),
+			backoffRescan:              newBackoff(opts),
+			backoffResync:              newBackoff(opts),
+			backoffRetireAddresses:     newBackoff(opts),
+			backoffServerHeartbeat:     newBackoff(opts),
+		},
+		staticRegenerateChan:  make(chan struct{}, 1),
+		staticRescanChan:      make(chan struct{}, 1),
+		pendingRescan:         make(map[types.UnlockHash]struct{}),
+		staticRetentionPeriod: opts.RetentionPeriod,
+		staticDefaultPoolSize: opts.PoolSize,
+		staticPoolCoverage:    opts.PoolCoverage,
+		staticPoolStrategy:    opts.PoolStrategy,
+		staticBGCtx:           bgCtx,
+		staticDeps:            deps,
+		staticThreadCancel:    cancel,
+		staticCtx:             ctx,
+		staticDB:              database,
+		staticLogger:          log,
+		staticServerDomain:    domain,
+		staticSkyd:            skyd,
 	}
 
 	// Create lock client.
 	lockClient := lock.NewClient(p.staticColLocks())
 	p.staticLockClient = lockClient
 
+	// Create the address generator. A seed's addresses are derived
+	// starting at index 0 just like skyd's, so skyd's own seed would hand
+	// out addresses skyd already uses.
+	skydGenerator := &skydAddressGenerator{
+		staticDeps: deps,
+		staticSkyd: skyd,
+	}
+	p.staticGenerator = skydGenerator
+	if opts.AddressSeed != "" {
+		seed, err := parseSeed(opts.AddressSeed)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to parse address seed")
+		}
+		owned, err := skydGenerator.Owns(seedAddress(seed, 0))
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to check address seed against skyd's wallet")
+		}
+		if owned {
+			return nil, errAddressSeedIsSkydSeed
+		}
+		p.staticGenerator = newSeedAddressGenerator(p.staticColConfig(), seed)
+	}
+
 	// Create indexes.
 	if err := p.staticCreateIndexes(ctx); err != nil {
 		return nil, errors.AddContext(err, "failed to create indexes")
 	}
 
-	// Kick off creation of addresses in non-testing builds. This is not
-	// really necessary but it will prevent the first user ever from getting
-	// an error when trying to fetch an address in production.
-	if build.Release != "testing" {
-		p.staticWG.Add(1)
-		go func() {
-			p.threadedRegenerateAddresses()
-			p.staticWG.Done()
-		}()
-	}
 	return p, nil
 }
 
 // Health returns some health information about the promoter.
 func (p *Promoter) Health() Health {
 	_, skydErr := p.staticSkyd.DaemonReadyGet()
+	backoffs := make(map[string]BackoffState, len(p.staticBackoffs))
+	for name, b := range p.staticBackoffs {
+		backoffs[name] = b.managedState()
+	}
+	p.mu.Lock()
+	walletErr := p.walletErr
+	p.mu.Unlock()
 	return Health{
 		Database: p.staticDB.Client().Ping(p.staticCtx, nil),
 		Skyd:     skydErr,
+		Wallet:   walletErr,
+		Backoffs: backoffs,
+	}
+}
+
+// Metrics returns metrics about the promoter's operation.
+func (p *Promoter) Metrics() Metrics {
+	p.mu.Lock()
+	pt := p.poolTarget
+	p.mu.Unlock()
+	return Metrics{
+		AccountsCache: p.staticAccounts.CacheStats(),
+		PoolTarget:    pt,
 	}
 }
 
 // initBackgroundThreads starts the background threads that the db requires.
 func (p *Promoter) initBackgroundThreads(f updateFunc) {
+	// Make sure skyd's wallet is the one the server's addresses were
+	// generated with before doing anything else. A mismatch doesn't
+	// prevent the promoter from starting since the existing addresses
+	// still need to be watched.
+	if _, err := p.managedCheckWalletFingerprint(p.staticBGCtx); err != nil && !errors.Contains(err, errWalletMismatch) {
+		p.staticLogger.WithError(err).Warn("Failed to verify wallet fingerprint on startup")
+	}
+
 	// Start watching the collection that contains the addresses we want
 	// skyd to watch.
 	p.staticWG.Add(1)
@@ -153,6 +373,31 @@ func (p *Promoter) initBackgroundThreads(f updateFunc) {
 		defer p.staticWG.Done()
 		p.threadedCreditTransactions()
 	}()
+	p.staticWG.Add(1)
+	go func() {
+		defer p.staticWG.Done()
+		p.threadedRetireAddresses()
+	}()
+	p.staticWG.Add(1)
+	go func() {
+		defer p.staticWG.Done()
+		p.threadedMaintainAddressPool()
+	}()
+	p.staticWG.Add(1)
+	go func() {
+		defer p.staticWG.Done()
+		p.threadedServerHeartbeat()
+	}()
+	p.staticWG.Add(1)
+	go func() {
+		defer p.staticWG.Done()
+		p.threadedResync()
+	}()
+	p.staticWG.Add(1)
+	go func() {
+		defer p.staticWG.Done()
+		p.threadedRescan()
+	}()
 }
 
 // staticAddrDiff returns a diff of addresses that describes which addresses
@@ -202,100 +447,95 @@ func (p *Promoter) threadedCreditTransactions() {
 	if p.staticDeps.Disrupt("DisableThreadedCreditTransactions") {
 		return
 	}
+	p.threadedRetryLoop(backoffCreditTransactions, txnPollInterval, p.managedCreditTransactions)
+}
 
-	t := time.NewTicker(txnPollInterval)
-	defer t.Stop()
-LOOP:
+// managedCreditTransactions fetches all uncredited txns from the db one-by-one
+// and submits them to the credit system. An error is returned if the
+// iteration had to be aborted early.
+func (p *Promoter) managedCreditTransactions() error {
+	// Get credit conversion rate at the beginning of this iteration.
+	cr, err := p.staticConversionRate()
+	if err != nil {
+		p.staticLogger.WithError(err).Error("Failed to fetch siacoin conversion rate")
+		return err // retry later
+	}
+
+	// Loop over txns one-by-one.
 	for {
-		select {
-		case <-p.staticBGCtx.Done():
-			return
-		case <-t.C:
+		// Fetch an transaction that the credit system doesn't know
+		// about yet.
+		currentTime := time.Now().UTC()
+		sr := p.staticColTransactions().FindOneAndUpdate(p.staticBGCtx, bson.M{
+			"credited": false,
+			"credited_at": bson.M{
+				"$lt": currentTime.Add(-txnPollInterval),
+			},
+		}, bson.M{
+			"$set": bson.M{
+				"credited_at": currentTime,
+			},
+		})
+		if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+			return nil // no more txns in this iteration
 		}
-
-		// Get credit conversion rate at the beginning of this iteration.
-		cr, err := p.staticConversionRate()
-		if err != nil {
-			p.staticLogger.WithError(err).Error("Failed to fetch siacoin conversion rate")
-			continue // retry later
+		if sr.Err() != nil {
+			p.staticLogger.WithError(sr.Err()).Error("Failed to fetch another uncredited txn")
+			return sr.Err() // db failure, try again later
 		}
 
-		// Loop over txns one-by-one.
-		for {
-			// Fetch an transaction that the credit system doesn't know
-			// about yet.
-			currentTime := time.Now().UTC()
-			sr := p.staticColTransactions().FindOneAndUpdate(p.staticBGCtx, bson.M{
-				"credited": false,
-				"credited_at": bson.M{
-					"$lt": currentTime.Add(-txnPollInterval),
-				},
-			}, bson.M{
-				"$set": bson.M{
-					"credited_at": currentTime,
-				},
-			})
-			if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
-				continue LOOP // no more txns in this iteration
-			}
-			if sr.Err() != nil {
-				p.staticLogger.WithError(sr.Err()).Error("Failed to fetch another uncredited txn")
-				continue LOOP // db failure, try again later
-			}
-
-			// Decode txn.
-			var txn Transaction
-			if err := sr.Decode(&txn); err != nil {
-				build.Critical(fmt.Sprintf("failed to decode txn: %v", err))
-				p.staticLogger.WithError(err).Error("Failed to decode txn")
-				continue // try next txn
-			}
+		// Decode txn.
+		var txn Transaction
+		if err := sr.Decode(&txn); err != nil {
+			build.Critical(fmt.Sprintf("failed to decode txn: %v", err))
+			p.staticLogger.WithError(err).Error("Failed to decode txn")
+			continue // try next txn
+		}
 
-			// Fetch the user for the txn.
-			sr = p.staticColWatchedAddresses().FindOne(p.staticBGCtx, bson.M{
-				"_id": txn.Address,
-			})
-			if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
-				build.Critical("Address for txn doesn't exist - this should never happen")
-				p.staticLogger.WithError(sr.Err()).Error("Address for txn doesn't exist")
-				continue // try next
-			}
-			if sr.Err() != nil {
-				p.staticLogger.WithError(sr.Err()).Error("Failed to fetch address for txn")
-				continue LOOP // db failure, try again later
-			}
-			var wa WatchedAddress
-			if err := sr.Decode(&wa); err != nil {
-				build.Critical(fmt.Sprintf("failed to decode address: %v", err))
-				p.staticLogger.WithError(sr.Err()).Error("Failed to decode address for txn")
-				continue // try next
-			}
+		// Fetch the user for the txn.
+		sr = p.staticColWatchedAddresses().FindOne(p.staticBGCtx, bson.M{
+			"_id": txn.Address,
+		})
+		if errors.Contains(sr.Err(), mongo.ErrNoDocuments) {
+			build.Critical("Address for txn doesn't exist - this should never happen")
+			p.staticLogger.WithError(sr.Err()).Error("Address for txn doesn't exist")
+			continue // try next
+		}
+		if sr.Err() != nil {
+			p.staticLogger.WithError(sr.Err()).Error("Failed to fetch address for txn")
+			return sr.Err() // db failure, try again later
+		}
+		var wa WatchedAddress
+		if err := sr.Decode(&wa); err != nil {
+			build.Critical(fmt.Sprintf("failed to decode address: %v", err))
+			p.staticLogger.WithError(sr.Err()).Error("Failed to decode address for txn")
+			continue // try next
+		}
 
-			// Parse the amount to credit.
-			var amt types.Currency
-			if _, err := fmt.Sscan(txn.Value, &amt); err != nil {
-				p.staticLogger.WithError(sr.Err()).Error("Failed to parse txn amount")
-				continue // try next
-			}
+		// Parse the amount to credit.
+		var amt types.Currency
+		if _, err := fmt.Sscan(txn.Value, &amt); err != nil {
+			p.staticLogger.WithError(sr.Err()).Error("Failed to parse txn amount")
+			continue // try next
+		}
 
-			// Send txn to credit system.
-			if err := p.staticCreditTxn(wa.UserSub, txn.TxnID, amt, cr); err != nil {
-				p.staticLogger.WithError(sr.Err()).Error("Failed to submit txn to credit system")
-				continue LOOP // something is wrong with the credit system - skip iteration
-			}
+		// Send txn to credit system.
+		if err := p.staticCreditTxn(wa.UserSub, txn.TxnID, amt, cr); err != nil {
+			p.staticLogger.WithError(err).Error("Failed to submit txn to credit system")
+			return err // something is wrong with the credit system - skip iteration
+		}
 
-			// Upon success mark it as credited.
-			_, err := p.staticColTransactions().UpdateOne(p.staticBGCtx, bson.M{
-				"_id": txn.TxnID,
-			}, bson.M{
-				"$set": bson.M{
-					"credited": true,
-				},
-			})
-			if err != nil {
-				p.staticLogger.WithError(err).Error("Failed to credit txn")
-				continue // try next txn
-			}
+		// Upon success mark it as credited.
+		_, err := p.staticColTransactions().UpdateOne(p.staticBGCtx, bson.M{
+			"_id": txn.TxnID,
+		}, bson.M{
+			"$set": bson.M{
+				"credited": true,
+			},
+		})
+		if err != nil {
+			p.staticLogger.WithError(err).Error("Failed to credit txn")
+			continue // try next txn
 		}
 	}
 }
@@ -303,55 +543,104 @@ LOOP:
 // threadedPollTransactions continuously polls skyd for transactions related to
 // watched addresses and writes them to the DB.
 func (p *Promoter) threadedPollTransactions() {
-	t := time.NewTicker(txnPollInterval)
-	defer t.Stop()
-	for {
-		select {
-		case <-p.staticBGCtx.Done():
-			return
-		case <-t.C:
-		}
-		p.staticLogger.WithTime(time.Now().UTC()).Info("Starting to poll transactions from skyd")
+	p.threadedRetryLoop(backoffPollTransactions, txnPollInterval, p.managedPollTransactions)
+}
 
-		// Get used addresses.
-		c, err := p.staticColWatchedAddresses().Find(p.staticBGCtx, bson.M{
-			"user_id": bson.M{
-				"$exists": true,
-				"$ne":     "",
-			},
-		})
+// managedPollTransactions fetches the transactions of all used addresses from
+// skyd and writes them to the DB. An error is returned if the iteration had to
+// be aborted early.
+func (p *Promoter) managedPollTransactions() error {
+	// Don't poll while skyd is rescanning for a resync.
+	resyncing, err := p.staticIsResyncing(p.staticBGCtx)
+	if err != nil {
+		p.staticLogger.WithError(err).Error("Failed to check for resync")
+		return err
+	}
+	if resyncing {
+		p.staticLogger.Debug("Not polling transactions while resyncing")
+		return nil
+	}
+
+	p.staticLogger.WithTime(time.Now().UTC()).Info("Starting to poll transactions from skyd")
+
+	// Get used addresses.
+	c, err := p.staticColWatchedAddresses().Find(p.staticBGCtx, bson.M{
+		"user_id": bson.M{
+			"$exists": true,
+			"$ne":     "",
+		},
+	})
+	if err != nil {
+		p.staticLogger.WithError(err).Error("Failed to fetch used addresses")
+		return err
+	}
+
+	// For each one get the related txns from skyd and save them to
+	// the db.
+	var nAddresssInserted, nTxnsInserted int
+	// Get addresses.
+	var was []WatchedAddress
+	if err := c.All(p.staticBGCtx, &was); err != nil {
+		p.staticLogger.WithError(err).Error("Failed to decode address")
+		return err
+	}
+
+	for _, wa := range was {
+		// Fetch related txns from skyd.
+		var txns []interface{}
+		txns, err = p.staticTxnsByAddress(wa.Address)
 		if err != nil {
-			p.staticLogger.WithError(err).Error("Failed to fetch used addresses")
-			continue
+			p.staticLogger.WithError(err).Error("Failed to fetch txns from skyd")
+			break // skyd is offline, retry later
 		}
 
-		// For each one get the related txns from skyd and save them to
-		// the db.
-		var nAddresssInserted, nTxnsInserted int
-		// Get addresses.
-		var was []WatchedAddress
-		if err := c.All(p.staticBGCtx, &was); err != nil {
-			p.staticLogger.WithError(err).Error("Failed to decode address")
-			continue // try next address
+		// Insert txns.
+		var n int
+		n, err = p.staticInsertTransactions(txns)
+		nTxnsInserted += n
+		if err != nil {
+			p.staticLogger.WithError(err).Error("Failed to insert txns into db")
+			break // db is malfunctioning, retry later
 		}
 
-		for _, wa := range was {
-			// Fetch related txns from skyd.
-			txns, err := p.staticTxnsByAddress(wa.Address)
+		// Remember when the address last received funds to not retire
+		// it.
+		if n > 0 {
+			_, err = p.staticColWatchedAddresses().UpdateOne(p.staticBGCtx, bson.M{
+				"_id": wa.Address,
+			}, bson.M{
+				"$set": bson.M{
+					"last_received_at": time.Now().UTC(),
+				},
+			})
 			if err != nil {
-				p.staticLogger.WithError(err).Error("Failed to fetch txns from skyd")
-				break // skyd is offline, wait for next interval
+				p.staticLogger.WithError(err).Error("Failed to update last received time")
+				break // db is malfunctioning, retry later
 			}
+		}
+		nAddresssInserted++
+	}
+	p.staticLogger.WithTime(time.Now().UTC()).Infof("Inserted %v transactions for %v addresses", nTxnsInserted, nAddresssInserted)
+	return err
+}
 
-			// Insert txns.
-			n, err := p.staticInsertTransactions(txns)
-			nTxnsInserted += n
-			if err != nil {
-				p.staticLogger.WithError(err).Error("Failed to insert txns into db")
-				break // db is malfunctioning, wait for next interval
-			}
-			nAddresssInserted++
+// threadedRetryLoop calls fn every interval until the promoter is closed. If
+// fn fails, it is retried using the backoff with the given name. The backoff
+// only ever extends the interval, so a failing fn is never called more often
+// than a succeeding one.
+func (p *Promoter) threadedRetryLoop(name string, interval time.Duration, fn func() error) {
+	b := p.staticBackoffs[name]
+	wait := interval
+	for {
+		if !sleepContext(p.staticBGCtx, wait) {
+			return // shutdown
+		}
+		if err := fn(); err != nil {
+			wait = b.managedFailureAtLeast(err, interval)
+			p.staticLogger.WithField("loop", name).WithField("retryIn", wait).Debug("Background loop failed")
+			continue
 		}
-		p.staticLogger.WithTime(time.Now().UTC()).Infof("Inserted %v transactions for %v addresses", nTxnsInserted, nAddresssInserted)
+		b.managedSuccess()
+		wait = interval
 	}
 }
@@ -46,6 +46,18 @@ func newTestPromoter(name, dbName, accountsAddr string) (*Promoter, *siatest.Tes
 // newTestPromoterWithDeps creates a Promoter instance for testing without the
 // background threads being launched.
 func newTestPromoterWithDeps(name string, deps dependencies.Dependencies, dbName, accountsAddr string) (*Promoter, *siatest.TestNode, error) {
+	return newTestPromoterWithDepsAndOptions(name, deps, dbName, accountsAddr, DefaultOptions())
+}
+
+// newTestPromoterWithOptions creates a Promoter instance for testing with
+// custom options.
+func newTestPromoterWithOptions(name, dbName, accountsAddr string, opts Options) (*Promoter, *siatest.TestNode, error) {
+	return newTestPromoterWithDepsAndOptions(name, dependencies.ProdDependencies, dbName, accountsAddr, opts)
+}
+
+// newTestPromoterWithDepsAndOptions creates a Promoter instance for testing
+// with custom dependencies and options.
+func newTestPromoterWithDepsAndOptions(name string, deps dependencies.Dependencies, dbName, accountsAddr string, opts Options) (*Promoter, *siatest.TestNode, error) {
 	// Create discard logger.
 	logger := logrus.New()
 	logger.SetOutput(io.Discard)
@@ -58,7 +70,7 @@ func newTestPromoterWithDeps(name string, deps dependencies.Dependencies, dbName
 
 	// Create promoter.
 	ac := NewAccountsClient(accountsAddr)
-	p, err := New(context.Background(), deps, ac, &skyd.Client, logrus.NewEntry(logger), testURI, testUsername, testPassword, name, dbName)
+	p, err := New(context.Background(), deps, ac, &skyd.Client, logrus.NewEntry(logger), testURI, testUsername, testPassword, name, dbName, opts)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -82,7 +94,7 @@ func newTestPromoterWithUpdateFunc(name, dbName, accountsAddr string, f updateFu
 		return nil, nil, err
 	}
 	ac := NewAccountsClient(accountsAddr)
-	p, err := newPromoter(context.Background(), dependencies.ProdDependencies, ac, &skyd.Client, logEntry, client, name, dbName)
+	p, err := newPromoter(context.Background(), dependencies.ProdDependencies, ac, &skyd.Client, logEntry, client, name, dbName, DefaultOptions())
 	if err != nil {
 		return nil, nil, errors.Compose(err, client.Disconnect(ctx))
 	}
@@ -109,7 +121,7 @@ func TestPromoterHealth(t *testing.T) {
 			t.Fatal(err)
 		}
 	}()
-	if ph := p.Health(); ph.Database != nil || ph.Skyd != nil {
+	if ph := p.Health(); ph.Database != nil || ph.Skyd != nil || ph.Wallet != nil {
 		t.Fatal("not healthy", ph)
 	}
 }
@@ -204,7 +216,9 @@ func TestPollTransactions(t *testing.T) {
 
 	// Fill the database with addresses by running address regeneration once
 	// manually.
-	p.threadedRegenerateAddresses()
+	if err := p.managedRegenerateAddresses(); err != nil {
+		t.Fatal(err)
+	}
 
 	// Get an address for a user.
 	user := "user"
@@ -276,7 +290,9 @@ func TestCreditTransactions(t *testing.T) {
 
 	// Fill the database with addresses by running address regeneration once
 	// manually.
-	p.threadedRegenerateAddresses()
+	if err := p.managedRegenerateAddresses(); err != nil {
+		t.Fatal(err)
+	}
 
 	// Get an address for a user.
 	user := "user"
@@ -0,0 +1,158 @@
+package promoter
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/build"
+	"go.sia.tech/siad/types"
+)
+
+var (
+	// rescanCoalesceWindow is the time the rescan worker waits after the
+	// first address that requires a rescan was queued. All addresses
+	// queued within that window are rescanned together.
+	rescanCoalesceWindow = build.Select(build.Var{
+		Dev:      5 * time.Second,
+		Standard: 30 * time.Second,
+		Testing:  time.Second,
+	}).(time.Duration)
+)
+
+// managedQueueRescan queues addresses that require a rescan of the blockchain
+// to pick up on past transactions. The addresses are added to skyd by the
+// rescan.
+//
+// NOTE: skyd's /wallet/watch endpoint always rescans from the genesis block.
+// It doesn't accept a start height, so rescanning from the height an address
+// was created at isn't possible without changing skyd. Coalescing rescans is
+// the best we can do.
+func (p *Promoter) managedQueueRescan(addrs []types.UnlockHash) {
+	if len(addrs) == 0 {
+		return
+	}
+	p.mu.Lock()
+	for _, addr := range addrs {
+		p.pendingRescan[addr] = struct{}{}
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.staticRescanChan <- struct{}{}:
+	default:
+	}
+}
+
+// managedDequeueRescan removes addresses from the queue, e.g. because they were
+// deleted before the rescan.
+func (p *Promoter) managedDequeueRescan(addrs []types.UnlockHash) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, addr := range addrs {
+		delete(p.pendingRescan, addr)
+	}
+}
+
+// managedPendingRescan removes all queued addresses from the queue and returns
+// them.
+func (p *Promoter) managedPendingRescan() []types.UnlockHash {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addrs := make([]types.UnlockHash, 0, len(p.pendingRescan))
+	for addr := range p.pendingRescan {
+		addrs = append(addrs, addr)
+	}
+	p.pendingRescan = make(map[types.UnlockHash]struct{})
+	return addrs
+}
+
+// threadedRescan triggers a single rescan for all addresses queued within
+// rescanCoalesceWindow. That way adding many used addresses one after another
+// doesn't cause a rescan for each one of them.
+func (p *Promoter) threadedRescan() {
+	b := p.staticBackoffs[backoffRescan]
+	for {
+		select {
+		case <-p.staticBGCtx.Done():
+			return // shutdown
+		case <-p.staticRescanChan:
+		}
+		// Wait for more addresses to be queued.
+		if !sleepContext(p.staticBGCtx, rescanCoalesceWindow) {
+			return // shutdown
+		}
+		for {
+			err := p.managedRescan()
+			if err == nil {
+				b.managedSuccess()
+				break
+			}
+			wait := b.managedFailure(err)
+			p.staticLogger.WithError(err).WithField("retryIn", wait).Error("Failed to rescan")
+			if !sleepContext(p.staticBGCtx, wait) {
+				return // shutdown
+			}
+		}
+	}
+}
+
+// managedRescan adds the queued addresses to skyd with 'unused' == false which
+// causes skyd to rescan the blockchain. If that fails, the addresses which are
+// still watched are queued again.
+func (p *Promoter) managedRescan() error {
+	addrs := p.managedPendingRescan()
+	if len(addrs) == 0 {
+		return nil
+	}
+	p.staticLogger.WithField("addresses", len(addrs)).Info("Rescanning blockchain for used addresses")
+	var err error
+	if p.staticDeps.Disrupt("FailRescan") {
+		err = errors.New("rescan failed")
+	} else {
+		err = p.staticSkyd.WalletWatchAddPost(addrs, false)
+	}
+	if err == nil {
+		return nil
+	}
+	err = errors.AddContext(err, "failed to rescan addresses")
+
+	// Addresses might have been deleted while the rescan was running.
+	// Their removal from the queue happened before they were queued
+	// again, so only queue the ones which are still in the database. If
+	// that can't be checked, queue all of them to not miss any used
+	// address.
+	watched, filterErr := p.staticWatchedAddressesIn(p.staticBGCtx, addrs)
+	if filterErr != nil {
+		p.staticLogger.WithError(filterErr).Warn("Failed to filter addresses to rescan")
+		watched = addrs
+	}
+	p.mu.Lock()
+	for _, addr := range watched {
+		p.pendingRescan[addr] = struct{}{}
+	}
+	p.mu.Unlock()
+	return err
+}
+
+// staticWatchedAddressesIn returns the given addresses which are in the
+// watched addresses collection.
+func (p *Promoter) staticWatchedAddressesIn(ctx context.Context, addrs []types.UnlockHash) ([]types.UnlockHash, error) {
+	c, err := p.staticColWatchedAddresses().Find(ctx, bson.M{
+		"_id": bson.M{"$in": addrs},
+	}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var was []WatchedAddress
+	if err := c.All(ctx, &was); err != nil {
+		return nil, err
+	}
+	watched := make([]types.UnlockHash, 0, len(was))
+	for _, wa := range was {
+		watched = append(watched, wa.Address)
+	}
+	return watched, nil
+}
@@ -0,0 +1,132 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.sia.tech/siad/types"
+)
+
+// TestApplyUpdates is a unit test for applyUpdates.
+func TestApplyUpdates(t *testing.T) {
+	t.Parallel()
+
+	var calls []bool
+	var n []int
+	updateFn := func(unused bool, updates ...WatchedAddressUpdate) error {
+		calls = append(calls, unused)
+		n = append(n, len(updates))
+		return nil
+	}
+	update := WatchedAddressUpdate{OperationType: operationTypeInsert}
+
+	// No updates means no calls.
+	if err := applyUpdates(updateFn, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 0 {
+		t.Fatal("updateFn shouldn't be called", calls)
+	}
+
+	// A single used address only causes a rescan for itself.
+	noRescan := []WatchedAddressUpdate{update, update, update}
+	rescan := []WatchedAddressUpdate{update}
+	if err := applyUpdates(updateFn, noRescan, rescan); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || !calls[0] || calls[1] || n[0] != 3 || n[1] != 1 {
+		t.Fatal("wrong calls", calls, n)
+	}
+}
+
+// TestQueueRescan is a unit test for managedQueueRescan and
+// managedPendingRescan.
+func TestQueueRescan(t *testing.T) {
+	t.Parallel()
+
+	p := &Promoter{
+		pendingRescan:    make(map[types.UnlockHash]struct{}),
+		staticRescanChan: make(chan struct{}, 1),
+	}
+
+	// Queue the same addresses twice.
+	var addr1, addr2 types.UnlockHash
+	fastrand.Read(addr1[:])
+	fastrand.Read(addr2[:])
+	p.managedQueueRescan([]types.UnlockHash{addr1, addr2})
+	p.managedQueueRescan([]types.UnlockHash{addr2})
+
+	// The worker should be signaled once.
+	select {
+	case <-p.staticRescanChan:
+	default:
+		t.Fatal("worker wasn't signaled")
+	}
+	select {
+	case <-p.staticRescanChan:
+		t.Fatal("signals should be coalesced")
+	default:
+	}
+
+	// The addresses are deduplicated and the queue is emptied.
+	if addrs := p.managedPendingRescan(); len(addrs) != 2 {
+		t.Fatal("wrong number of addresses", len(addrs))
+	}
+	if addrs := p.managedPendingRescan(); len(addrs) != 0 {
+		t.Fatal("queue should be empty", len(addrs))
+	}
+
+	// Dequeued addresses aren't rescanned.
+	p.managedQueueRescan([]types.UnlockHash{addr1, addr2})
+	p.managedDequeueRescan([]types.UnlockHash{addr1})
+	if addrs := p.managedPendingRescan(); len(addrs) != 1 || addrs[0] != addr2 {
+		t.Fatal("wrong addresses", addrs)
+	}
+}
+
+// TestRescanRequeuesWatchedAddresses tests that a failed rescan only queues the
+// addresses again which weren't deleted in the meantime.
+func TestRescanRequeuesWatchedAddresses(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	deps := newDependencyDisruptOnKeyword("FailRescan")
+	p, node, err := newTestPromoterWithDeps(t.Name(), deps, t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Only the first address is in the database.
+	var watched, deleted types.UnlockHash
+	fastrand.Read(watched[:])
+	fastrand.Read(deleted[:])
+	_, err = p.staticColWatchedAddresses().InsertOne(context.Background(), p.newUnusedWatchedAddress(watched))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queue both without signaling the worker and rescan.
+	p.mu.Lock()
+	p.pendingRescan[watched] = struct{}{}
+	p.pendingRescan[deleted] = struct{}{}
+	p.mu.Unlock()
+	if err := p.managedRescan(); err == nil {
+		t.Fatal("rescan should fail")
+	}
+
+	// Only the watched address is queued again.
+	if addrs := p.managedPendingRescan(); len(addrs) != 1 || addrs[0] != watched {
+		t.Fatal("wrong addresses", addrs)
+	}
+}
@@ -0,0 +1,194 @@
+package promoter
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/build"
+	"go.sia.tech/siad/types"
+)
+
+const (
+	// AuditActionResyncServer is the action recorded when a resync of a
+	// server's skyd is requested.
+	AuditActionResyncServer = "resync_server"
+)
+
+var (
+	// resyncCheckInterval is the interval at which a promoter checks
+	// whether a resync was requested and how far the rescan got.
+	resyncCheckInterval = build.Select(build.Var{
+		Dev:      5 * time.Second,
+		Standard: 30 * time.Second,
+		Testing:  time.Second,
+	}).(time.Duration)
+)
+
+type (
+	// ServerResync describes a pending resync of a server's skyd. While a
+	// resync is pending, the server doesn't poll transactions and the
+	// transactions of its addresses aren't credited.
+	ServerResync struct {
+		// RequestedAt is the time the resync was requested.
+		RequestedAt time.Time `bson:"requested_at" json:"requestedat"`
+
+		// StartedAt is the time all addresses were re-added to skyd.
+		// It is zero until the server picked up the request.
+		StartedAt time.Time `bson:"started_at,omitempty" json:"startedat"`
+
+		// Addresses is the number of addresses that were re-added.
+		Addresses int64 `bson:"addresses" json:"addresses"`
+
+		// Height is the height the wallet has rescanned up to and
+		// TargetHeight the height of the consensus.
+		Height       types.BlockHeight `bson:"height" json:"height"`
+		TargetHeight types.BlockHeight `bson:"target_height" json:"targetheight"`
+	}
+)
+
+// ResyncServer requests the given server to re-add all watched addresses to
+// its skyd and rescan the blockchain. The server picks up the request the next
+// time it checks for one. Until the rescan is done, the server doesn't poll
+// transactions and the transactions of its addresses aren't credited. If the
+// server doesn't exist, mongo.ErrNoDocuments is returned.
+func (p *Promoter) ResyncServer(ctx context.Context, server string) error {
+	return p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		res, err := p.staticColServers().UpdateOne(sc, bson.M{
+			"_id": server,
+		}, bson.M{
+			"$set": bson.M{
+				"resync": ServerResync{
+					RequestedAt: time.Now().UTC(),
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if res.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return p.staticInsertAuditEntry(sc, AuditEntry{
+			Action: AuditActionResyncServer,
+			Server: server,
+			Params: map[string]string{
+				"server": server,
+			},
+		})
+	})
+}
+
+// staticIsResyncing returns whether this server has a pending resync.
+func (p *Promoter) staticIsResyncing(ctx context.Context) (bool, error) {
+	n, err := p.staticColServers().CountDocuments(ctx, bson.M{
+		"_id":    p.staticServerDomain,
+		"resync": bson.M{"$exists": true},
+	})
+	return n > 0, err
+}
+
+// threadedResync periodically checks for resync requests for this server and
+// tracks the progress of a running rescan.
+func (p *Promoter) threadedResync() {
+	p.threadedRetryLoop(backoffResync, resyncCheckInterval, p.managedResync)
+}
+
+// managedResync starts a requested resync or updates the progress of a
+// running one. Once skyd's wallet caught up with the consensus, the resync is
+// considered done.
+func (p *Promoter) managedResync() error {
+	var server Server
+	err := p.staticColServers().FindOne(p.staticBGCtx, bson.M{
+		"_id": p.staticServerDomain,
+	}).Decode(&server)
+	if errors.Contains(err, mongo.ErrNoDocuments) {
+		return nil // no heartbeat yet
+	}
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch server")
+	}
+	resync := server.Resync
+	if resync == nil {
+		return nil // nothing to do
+	}
+
+	// Start the resync if it wasn't started yet or it was requested again
+	// since it was started.
+	if resync.StartedAt.IsZero() || resync.RequestedAt.After(resync.StartedAt) {
+		return p.managedStartResync(resync.RequestedAt)
+	}
+
+	// Check the progress.
+	wg, err := p.staticSkyd.WalletGet()
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch wallet status")
+	}
+	cg, err := p.staticSkyd.ConsensusGet()
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch consensus status")
+	}
+	if !wg.Rescanning && wg.Height >= cg.Height {
+		// Only finish the resync if it wasn't requested again in the
+		// meantime.
+		_, err = p.staticColServers().UpdateOne(p.staticBGCtx, bson.M{
+			"_id":                 p.staticServerDomain,
+			"resync.requested_at": resync.RequestedAt,
+		}, bson.M{
+			"$unset": bson.M{"resync": ""},
+			"$set":   bson.M{"last_resync_at": time.Now().UTC()},
+		})
+		if err != nil {
+			return errors.AddContext(err, "failed to finish resync")
+		}
+		p.staticLogger.WithField("addresses", resync.Addresses).Info("Finished resync")
+		return nil
+	}
+	_, err = p.staticColServers().UpdateOne(p.staticBGCtx, bson.M{
+		"_id":                 p.staticServerDomain,
+		"resync.requested_at": resync.RequestedAt,
+	}, bson.M{
+		"$set": bson.M{
+			"resync.height":        wg.Height,
+			"resync.target_height": cg.Height,
+		},
+	})
+	return err
+}
+
+// managedStartResync re-adds all watched addresses to skyd with a rescan of the
+// blockchain.
+func (p *Promoter) managedStartResync(requestedAt time.Time) error {
+	c, err := p.staticColWatchedAddresses().Find(p.staticBGCtx, bson.M{}, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return errors.AddContext(err, "failed to fetch watched addresses")
+	}
+	var was []WatchedAddress
+	if err := c.All(p.staticBGCtx, &was); err != nil {
+		return errors.AddContext(err, "failed to decode watched addresses")
+	}
+	addrs := make([]types.UnlockHash, 0, len(was))
+	for _, wa := range was {
+		addrs = append(addrs, wa.Address)
+	}
+	p.staticLogger.WithField("addresses", len(addrs)).Info("Starting resync")
+
+	// Adding the addresses with 'unused' == false makes skyd rescan the
+	// blockchain.
+	if err := p.staticSkyd.WalletWatchAddPost(addrs, false); err != nil {
+		return errors.AddContext(err, "failed to add addresses to skyd")
+	}
+	_, err = p.staticColServers().UpdateOne(p.staticBGCtx, bson.M{
+		"_id":                 p.staticServerDomain,
+		"resync.requested_at": requestedAt,
+	}, bson.M{
+		"$set": bson.M{
+			"resync.started_at": time.Now().UTC(),
+			"resync.addresses":  int64(len(addrs)),
+		},
+	})
+	return err
+}
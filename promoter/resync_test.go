@@ -0,0 +1,173 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.sia.tech/siad/build"
+	"go.sia.tech/siad/types"
+)
+
+// TestResyncServer tests requesting a resync and the promoter finishing it in
+// the background.
+func TestResyncServer(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx := context.Background()
+
+	// Unknown servers can't be resynced.
+	if err := p.ResyncServer(ctx, "unknown"); !errors.Contains(err, mongo.ErrNoDocuments) {
+		t.Fatal("expected ErrNoDocuments", err)
+	}
+
+	// Wait for the heartbeat and request a resync.
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		return p.ResyncServer(ctx, p.staticServerDomain)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server should be resyncing.
+	resyncing, err := p.staticIsResyncing(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resyncing {
+		t.Fatal("server should be resyncing")
+	}
+
+	// Eventually the resync should be done.
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		var server Server
+		err := p.staticColServers().FindOne(ctx, bson.M{"_id": p.staticServerDomain}).Decode(&server)
+		if err != nil {
+			return err
+		}
+		if server.Resync != nil || server.LastResyncAt.IsZero() {
+			return errors.New("resync not done yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	isResyncing, err := p.staticIsResyncing(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isResyncing {
+		t.Fatal("server shouldn't be resyncing anymore")
+	}
+
+	// The request should be in the audit log.
+	entries, err := p.AuditEntries(ctx, AuditFilter{Server: p.staticServerDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != AuditActionResyncServer {
+		t.Fatal("wrong audit entries", entries)
+	}
+}
+
+// TestResyncKeepsCrediting tests that a transaction which a healthy server
+// recorded for an address of a resyncing server is still credited.
+func TestResyncKeepsCrediting(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx := context.Background()
+
+	// Add another server with a pending resync which no promoter picks
+	// up and an address of that server.
+	other := "other.server"
+	_, err = p.staticColServers().InsertOne(ctx, Server{
+		Domain:   other,
+		LastSeen: time.Now().UTC(),
+		Healthy:  true,
+		State:    ServerStateActive,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.ResyncServer(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	var addr types.UnlockHash
+	addr[0] = 1
+	wa := p.newUnusedWatchedAddress(addr)
+	wa.Server = other
+	wa.UserSub = "user"
+	wa.Primary = true
+	if _, err := p.staticColWatchedAddresses().InsertOne(ctx, wa); err != nil {
+		t.Fatal(err)
+	}
+
+	// The healthy server records a txn for that address.
+	txnID := types.TransactionID{1}
+	_, err = p.staticInsertTransactions([]interface{}{
+		Transaction{Address: addr, TxnID: txnID, Value: types.SiacoinPrecision.String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The txn should be credited even though the address' server is
+	// still resyncing.
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		if err := p.managedCreditTransactions(); err != nil {
+			return err
+		}
+		var txn Transaction
+		if err := p.staticColTransactions().FindOne(ctx, bson.M{"_id": txnID}).Decode(&txn); err != nil {
+			return err
+		}
+		if !txn.Credited {
+			return errors.New("txn not credited yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server Server
+	if err := p.staticColServers().FindOne(ctx, bson.M{"_id": other}).Decode(&server); err != nil {
+		t.Fatal(err)
+	}
+	if server.Resync == nil {
+		t.Fatal("server should still be resyncing")
+	}
+}
@@ -0,0 +1,216 @@
+package promoter
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	lock "github.com/square/mongo-lock"
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/build"
+	"go.sia.tech/siad/types"
+)
+
+const (
+	// colArchivedAddressesName is the name of the collection that contains
+	// retired addresses which are no longer watched.
+	colArchivedAddressesName = "archived_addresses"
+
+	// lockResourceRetireAddresses is the resource locked while retiring
+	// idle addresses. Only one promoter retires addresses at a time to
+	// avoid them racing to archive the same addresses.
+	lockResourceRetireAddresses = "retire-addresses"
+
+	// AuditActionRetireAddress is the action recorded when an idle address
+	// is moved to the archive.
+	AuditActionRetireAddress = "retire_address"
+
+	// AuditActionReactivateAddress is the action recorded when an archived
+	// address is watched again.
+	AuditActionReactivateAddress = "reactivate_address"
+)
+
+var (
+	// retirementInterval is the interval at which idle addresses are
+	// retired.
+	retirementInterval = build.Select(build.Var{
+		Dev:      time.Minute,
+		Standard: time.Hour,
+		Testing:  time.Second,
+	}).(time.Duration)
+
+	// retirementBatchSize is the max number of addresses retired within a
+	// single iteration.
+	retirementBatchSize = build.Select(build.Var{
+		Dev:      int64(100),
+		Standard: int64(1000),
+		Testing:  int64(10),
+	}).(int64)
+)
+
+type (
+	// ArchivedAddress is an address that was retired from the watched
+	// addresses collection.
+	ArchivedAddress struct {
+		WatchedAddress `bson:",inline"`
+		ArchivedAt     time.Time `bson:"archived_at"`
+	}
+)
+
+// staticColArchivedAddresses returns the collection used to store retired
+// addresses.
+func (p *Promoter) staticColArchivedAddresses() *mongo.Collection {
+	return p.staticDB.Collection(colArchivedAddressesName)
+}
+
+// threadedRetireAddresses periodically retires idle addresses if a retention
+// period was configured. The addresses of all servers are retired by whichever
+// promoter holds the retirement lock. That way addresses of servers which are
+// gone for good are retired too.
+func (p *Promoter) threadedRetireAddresses() {
+	if p.staticRetentionPeriod == 0 {
+		return // retention disabled
+	}
+	p.threadedRetryLoop(backoffRetireAddresses, retirementInterval, func() error {
+		lockID := fmt.Sprintf("%s-%s", p.staticServerDomain, hex.EncodeToString(fastrand.Bytes(8)))
+		err := p.staticLockClient.XLock(p.staticBGCtx, lockResourceRetireAddresses, lockID, lock.LockDetails{
+			Owner: "siacoin-promoter",
+			Host:  p.staticServerDomain,
+			TTL:   lockTTL,
+		})
+		if err == lock.ErrAlreadyLocked {
+			return nil // another promoter is retiring addresses
+		}
+		if err != nil {
+			return errors.AddContext(err, "failed to lock address retirement")
+		}
+		defer func() {
+			if _, err := p.staticLockClient.Unlock(p.staticBGCtx, lockID); err != nil {
+				p.staticLogger.WithError(err).Error("Failed to unlock address retirement")
+			}
+		}()
+
+		n, err := p.managedRetireAddresses(p.staticBGCtx, time.Now().UTC().Add(-p.staticRetentionPeriod))
+		if err != nil {
+			p.staticLogger.WithError(err).Error("Failed to retire idle addresses")
+		} else if n > 0 {
+			p.staticLogger.WithField("retired", n).Info("Retired idle addresses")
+		}
+		return err
+	})
+}
+
+// managedRetireAddresses moves all addresses which were demoted before the
+// cutoff and didn't receive any funds since then to the archive. Removing them
+// from the watched addresses collection causes the address watcher to remove
+// them from skyd. It returns the number of retired addresses.
+func (p *Promoter) managedRetireAddresses(ctx context.Context, cutoff time.Time) (int, error) {
+	// Demoted addresses from before the demotion time was recorded don't
+	// have one. Start their retention period now.
+	_, err := p.staticColWatchedAddresses().UpdateMany(ctx, bson.M{
+		"primary":    false,
+		"user_id":    bson.M{"$exists": true, "$ne": ""},
+		"demoted_at": bson.M{"$exists": false},
+	}, bson.M{
+		"$set": bson.M{
+			"demoted_at": time.Now().UTC(),
+		},
+	})
+	if err != nil {
+		return 0, errors.AddContext(err, "failed to backfill demotion times")
+	}
+
+	// Find the idle addresses.
+	c, err := p.staticColWatchedAddresses().Find(ctx, bson.M{
+		"primary":    false,
+		"user_id":    bson.M{"$exists": true, "$ne": ""},
+		"demoted_at": bson.M{"$lt": cutoff},
+		"$or": bson.A{
+			bson.M{"last_received_at": bson.M{"$exists": false}},
+			bson.M{"last_received_at": bson.M{"$lt": cutoff}},
+		},
+	}, options.Find().SetLimit(retirementBatchSize))
+	if err != nil {
+		return 0, err
+	}
+	var was []WatchedAddress
+	if err := c.All(ctx, &was); err != nil {
+		return 0, err
+	}
+
+	var retired int
+	for _, wa := range was {
+		// Don't retire addresses with txns that still need to be
+		// credited since crediting requires the address.
+		n, err := p.staticColTransactions().CountDocuments(ctx, bson.M{
+			"address_id": wa.Address,
+			"credited":   false,
+		}, options.Count().SetLimit(1))
+		if err != nil {
+			return retired, err
+		}
+		if n > 0 {
+			continue
+		}
+		err = p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+			aa := ArchivedAddress{
+				WatchedAddress: wa,
+				ArchivedAt:     time.Now().UTC(),
+			}
+			if _, err := p.staticColArchivedAddresses().InsertOne(sc, aa); err != nil {
+				return err
+			}
+			if _, err := p.staticColWatchedAddresses().DeleteOne(sc, bson.M{"_id": wa.Address}); err != nil {
+				return err
+			}
+			return p.staticInsertAuditEntry(sc, AuditEntry{
+				Action:  AuditActionRetireAddress,
+				Server:  wa.Server,
+				UserSub: wa.UserSub,
+				Params: map[string]string{
+					"address": wa.Address.String(),
+				},
+				Before: &AuditSnapshot{Addresses: []WatchedAddress{wa}},
+			})
+		})
+		if err != nil {
+			return retired, errors.AddContext(err, "failed to archive address")
+		}
+		retired++
+	}
+	return retired, nil
+}
+
+// ReactivateAddress moves an archived address back into the watched addresses
+// collection. This causes skyd to watch the address again and to rescan the
+// blockchain for payments to it. The address doesn't become primary again and
+// its retention period starts over.
+func (p *Promoter) ReactivateAddress(ctx context.Context, addr types.UnlockHash) error {
+	return p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		var aa ArchivedAddress
+		err := p.staticColArchivedAddresses().FindOneAndDelete(sc, bson.M{"_id": addr}).Decode(&aa)
+		if err != nil {
+			return err
+		}
+		wa := aa.WatchedAddress
+		wa.Primary = false
+		wa.DemotedAt = time.Now().UTC()
+		if _, err := p.staticColWatchedAddresses().InsertOne(sc, wa); err != nil {
+			return err
+		}
+		return p.staticInsertAuditEntry(sc, AuditEntry{
+			Action:  AuditActionReactivateAddress,
+			Server:  wa.Server,
+			UserSub: wa.UserSub,
+			Params: map[string]string{
+				"address": wa.Address.String(),
+			},
+			After: &AuditSnapshot{Addresses: []WatchedAddress{wa}},
+		})
+	})
+}
@@ -0,0 +1,167 @@
+package promoter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.sia.tech/siad/types"
+)
+
+// TestRetireAddresses is a unit test for managedRetireAddresses and
+// ReactivateAddress.
+func TestRetireAddresses(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Prepare addresses in various states.
+	//
+	// - idle: demoted long ago and never received anything
+	// - received: demoted long ago but received funds recently
+	// - uncredited: demoted long ago with an uncredited txn
+	// - recent: demoted recently
+	// - primary: still the user's primary address
+	// - unused: never assigned
+	user := "user"
+	longAgo := time.Now().UTC().Add(-time.Hour)
+	var idle, received, uncredited, recent, primary, unused types.UnlockHash
+	for i, addr := range []*types.UnlockHash{&idle, &received, &uncredited, &recent, &primary, &unused} {
+		addr[0] = byte(i + 1)
+	}
+	demoted := func(addr types.UnlockHash, demotedAt time.Time) WatchedAddress {
+		return WatchedAddress{
+			Address:    addr,
+			Server:     p.staticServerDomain,
+			UserSub:    user,
+			CreatedAt:  longAgo,
+			AssignedAt: longAgo,
+			DemotedAt:  demotedAt,
+		}
+	}
+	receivedWA := demoted(received, longAgo)
+	receivedWA.LastReceivedAt = time.Now().UTC()
+	primaryWA := demoted(primary, time.Time{})
+	primaryWA.Primary = true
+	_, err = p.staticColWatchedAddresses().InsertMany(context.Background(), []interface{}{
+		demoted(idle, longAgo),
+		receivedWA,
+		demoted(uncredited, longAgo),
+		demoted(recent, time.Now().UTC()),
+		primaryWA,
+		p.newUnusedWatchedAddress(unused),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.staticInsertTransactions([]interface{}{
+		Transaction{Address: uncredited, TxnID: types.TransactionID{1}, Value: types.SiacoinPrecision.String()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retire addresses that were idle for more than half an hour. Only the
+	// idle one should be retired.
+	n, err := p.managedRetireAddresses(context.Background(), time.Now().UTC().Add(-time.Minute*30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatal("wrong number of retired addresses", n)
+	}
+	err = p.staticColWatchedAddresses().FindOne(context.Background(), bson.M{"_id": idle}).Err()
+	if err != mongo.ErrNoDocuments {
+		t.Fatal("idle address should no longer be watched", err)
+	}
+	var aa ArchivedAddress
+	err = p.staticColArchivedAddresses().FindOne(context.Background(), bson.M{"_id": idle}).Decode(&aa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aa.UserSub != user || aa.ArchivedAt.IsZero() {
+		t.Fatal("wrong archived address", aa)
+	}
+	for _, addr := range []types.UnlockHash{received, uncredited, recent, primary, unused} {
+		err = p.staticColWatchedAddresses().FindOne(context.Background(), bson.M{"_id": addr}).Err()
+		if err != nil {
+			t.Fatal("address should still be watched", addr, err)
+		}
+	}
+
+	// The retired address is still listed for the user.
+	addrs, err := p.UserAddresses(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, addr := range addrs {
+		if addr.Address == idle {
+			found = addr.Archived
+		} else if addr.Archived {
+			t.Fatal("address shouldn't be archived", addr)
+		}
+	}
+	if !found {
+		t.Fatal("retired address should be listed as archived")
+	}
+
+	// Running it again doesn't retire anything.
+	n, err = p.managedRetireAddresses(context.Background(), time.Now().UTC().Add(-time.Minute*30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatal("no addresses should be retired", n)
+	}
+
+	// Reactivate the address. It's watched again but not primary and its
+	// retention period starts over.
+	if err := p.ReactivateAddress(context.Background(), idle); err != nil {
+		t.Fatal(err)
+	}
+	var wa WatchedAddress
+	err = p.staticColWatchedAddresses().FindOne(context.Background(), bson.M{"_id": idle}).Decode(&wa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wa.Primary || wa.UserSub != user || wa.DemotedAt.Before(longAgo.Add(time.Minute)) {
+		t.Fatal("wrong reactivated address", wa)
+	}
+	err = p.staticColArchivedAddresses().FindOne(context.Background(), bson.M{"_id": idle}).Err()
+	if err != mongo.ErrNoDocuments {
+		t.Fatal("address should no longer be archived", err)
+	}
+
+	// Reactivating it again fails.
+	if err := p.ReactivateAddress(context.Background(), idle); err != mongo.ErrNoDocuments {
+		t.Fatal("expected ErrNoDocuments", err)
+	}
+
+	// Both operations were audited.
+	for _, action := range []string{AuditActionRetireAddress, AuditActionReactivateAddress} {
+		entries, err := p.AuditEntries(context.Background(), AuditFilter{Action: action})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].UserSub != user {
+			t.Fatal("wrong audit entries", action, entries)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package promoter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gitlab.com/NebulousLabs/errors"
+	"gitlab.com/NebulousLabs/fastrand"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.sia.tech/siad/build"
+	"go.sia.tech/siad/types"
+)
+
+const (
+	// colServersName is the name of the collection that contains a
+	// heartbeat of every promoter.
+	colServersName = "servers"
+
+	// PoolStrategyAny assigns one of the oldest unused addresses
+	// regardless of which server generated it.
+	PoolStrategyAny = "any"
+
+	// PoolStrategyLocal prefers addresses generated by the server handling
+	// the request.
+	PoolStrategyLocal = "local"
+
+	// PoolStrategyRoundRobin cycles through the healthy servers.
+	PoolStrategyRoundRobin = "roundrobin"
+
+	// PoolStrategyWeighted picks a random healthy server. The chance of a
+	// server being picked is proportional to its health score.
+	PoolStrategyWeighted = "weighted"
+
+	// maxHealthScore is the health score of a server whose skyd is ready
+	// and whose background loops aren't failing. Every consecutive failure
+	// of a background loop lowers the score.
+	maxHealthScore = 100
+
+	// ServerStateActive is the state of a server whose addresses are
+	// assigned to users.
+	ServerStateActive = "active"
+
+	// ServerStateDraining is the state of a server whose addresses are
+	// still watched but no longer assigned to users. It also stops
+	// generating new addresses.
+	ServerStateDraining = "draining"
+
+	// ServerStateDead is the state of a server which was marked dead.
+	// Its unused addresses were deleted and its primary addresses were
+	// demoted.
+	ServerStateDead = "dead"
+
+	// AuditActionSetServerState is the action recorded when the state of a
+	// server is changed.
+	AuditActionSetServerState = "set_server_state"
+)
+
+var (
+	// serverHeartbeatInterval is the interval at which a promoter updates
+	// its entry in the servers collection.
+	serverHeartbeatInterval = build.Select(build.Var{
+		Dev:      10 * time.Second,
+		Standard: 30 * time.Second,
+		Testing:  time.Second,
+	}).(time.Duration)
+
+	// serverHeartbeatTimeout is the time after which a server that didn't
+	// send a heartbeat is no longer considered for assigning addresses.
+	serverHeartbeatTimeout = 3 * serverHeartbeatInterval
+)
+
+type (
+	// Server is an entry of the servers collection.
+	Server struct {
+		Domain   string    `bson:"_id" json:"domain"`
+		LastSeen time.Time `bson:"last_seen" json:"lastseen"`
+
+		// Healthy indicates whether the server's skyd was ready at the
+		// time of the last heartbeat.
+		Healthy bool `bson:"healthy" json:"healthy"`
+
+		// HealthScore is the health score of the server at the time of
+		// the last heartbeat. It is 0 if skyd wasn't ready and
+		// maxHealthScore if none of the server's background loops were
+		// failing.
+		HealthScore int64 `bson:"health_score" json:"healthscore"`
+
+		// State is the state of the server. Servers which were added
+		// before states were introduced don't have one and are
+		// considered active.
+		State string `bson:"state,omitempty" json:"state"`
+
+		// WalletFingerprint identifies the wallet the server generates
+		// addresses with. It is the fingerprint of WalletAddress, an
+		// address of the wallet which is stored the first time the
+		// server generates addresses. Both are only cleared by
+		// resetting the fingerprint.
+		WalletFingerprint string            `bson:"wallet_fingerprint,omitempty" json:"walletfingerprint,omitempty"`
+		WalletAddress     *types.UnlockHash `bson:"wallet_address,omitempty" json:"walletaddress,omitempty"`
+
+		// Resync is the pending resync of the server's skyd if there is
+		// one. LastResyncAt is the time the last resync finished.
+		Resync       *ServerResync `bson:"resync,omitempty" json:"resync,omitempty"`
+		LastResyncAt time.Time     `bson:"last_resync_at,omitempty" json:"lastresyncat"`
+	}
+)
+
+// healthScore computes the health score of a server from the readiness of its
+// skyd and the number of consecutive failures of its background loops.
+func healthScore(skydReady bool, failures uint64) int64 {
+	if !skydReady {
+		return 0
+	}
+	score := maxHealthScore / (1 + int64(failures))
+	if score == 0 {
+		score = 1
+	}
+	return score
+}
+
+// validPoolStrategy returns whether the strategy is known.
+func validPoolStrategy(strategy string) bool {
+	switch strategy {
+	case PoolStrategyAny, PoolStrategyLocal, PoolStrategyRoundRobin, PoolStrategyWeighted:
+		return true
+	}
+	return false
+}
+
+// ValidServerState returns whether the state is known.
+func ValidServerState(state string) bool {
+	switch state {
+	case ServerStateActive, ServerStateDraining, ServerStateDead:
+		return true
+	}
+	return false
+}
+
+// filterUnusedAddressesOf returns a filter for the unused addresses of a
+// server.
+func filterUnusedAddressesOf(server string) bson.M {
+	return bson.M{
+		"$or":    filterUnusedAddresses["$or"],
+		"server": server,
+	}
+}
+
+// staticColServers returns the collection used to store server heartbeats.
+func (p *Promoter) staticColServers() *mongo.Collection {
+	return p.staticDB.Collection(colServersName)
+}
+
+// threadedServerHeartbeat periodically updates the promoter's entry in the
+// servers collection.
+func (p *Promoter) threadedServerHeartbeat() {
+	if err := p.managedServerHeartbeat(); err != nil {
+		p.staticLogger.WithError(err).Error("Failed to send initial heartbeat")
+	}
+	p.threadedRetryLoop(backoffServerHeartbeat, serverHeartbeatInterval, p.managedServerHeartbeat)
+}
+
+// managedServerHeartbeat updates the promoter's entry in the servers
+// collection.
+func (p *Promoter) managedServerHeartbeat() error {
+	_, skydErr := p.staticSkyd.DaemonReadyGet()
+	var failures uint64
+	for _, b := range p.staticBackoffs {
+		failures += b.managedState().Failures
+	}
+	_, err := p.staticColServers().UpdateOne(p.staticBGCtx, bson.M{
+		"_id": p.staticServerDomain,
+	}, bson.M{
+		"$set": bson.M{
+			"last_seen":    time.Now().UTC(),
+			"healthy":      skydErr == nil,
+			"health_score": healthScore(skydErr == nil, failures),
+		},
+		"$setOnInsert": bson.M{
+			"state": ServerStateActive,
+		},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+// Servers returns all servers that ever sent a heartbeat or were marked dead.
+func (p *Promoter) Servers(ctx context.Context) ([]Server, error) {
+	c, err := p.staticColServers().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	servers := []Server{}
+	if err := c.All(ctx, &servers); err != nil {
+		return nil, err
+	}
+	for i := range servers {
+		if servers[i].State == "" {
+			servers[i].State = ServerStateActive
+		}
+	}
+	return servers, nil
+}
+
+// SetServerState changes the state of a server. Setting it to dead also does
+// what MarkServerDead does. Unlike MarkServerDead, the server then neither
+// generates nor hands out addresses until its state is set to active again.
+// Its deleted unused addresses are not restored when that happens.
+func (p *Promoter) SetServerState(ctx context.Context, server, state string) error {
+	if !ValidServerState(state) {
+		return fmt.Errorf("unknown server state '%v'", state)
+	}
+	return p.managedWithTransaction(ctx, func(sc mongo.SessionContext) error {
+		if state == ServerStateDead {
+			if err := p.staticMarkServerDead(sc, server); err != nil {
+				return err
+			}
+		}
+		before, err := p.staticUpdateServerState(sc, server, state)
+		if err != nil {
+			return err
+		}
+		return p.staticInsertAuditEntry(sc, AuditEntry{
+			Action: AuditActionSetServerState,
+			Server: server,
+			Params: map[string]string{
+				"server": server,
+				"before": before,
+				"state":  state,
+			},
+		})
+	})
+}
+
+// staticUpdateServerState updates the state of a server in the servers
+// collection and returns its previous state.
+func (p *Promoter) staticUpdateServerState(ctx context.Context, server, state string) (string, error) {
+	var before Server
+	err := p.staticColServers().FindOneAndUpdate(ctx, bson.M{
+		"_id": server,
+	}, bson.M{
+		"$set": bson.M{
+			"state": state,
+		},
+	}, options.FindOneAndUpdate().SetUpsert(true)).Decode(&before)
+	if err != nil && !errors.Contains(err, mongo.ErrNoDocuments) {
+		return "", err
+	}
+	if before.State == "" {
+		before.State = ServerStateActive
+	}
+	return before.State, nil
+}
+
+// staticServerState returns the state of the server. Unknown servers are
+// considered active.
+func (p *Promoter) staticServerState(ctx context.Context, server string) (string, error) {
+	var s Server
+	err := p.staticColServers().FindOne(ctx, bson.M{"_id": server}).Decode(&s)
+	if errors.Contains(err, mongo.ErrNoDocuments) || (err == nil && s.State == "") {
+		return ServerStateActive, nil
+	}
+	return s.State, err
+}
+
+// staticExcludedServers returns the servers whose addresses must not be
+// assigned. These are servers which are draining or dead and servers which
+// didn't send a heartbeat recently. Servers without an entry in the servers
+// collection are not excluded.
+func (p *Promoter) staticExcludedServers(ctx context.Context) ([]string, error) {
+	c, err := p.staticColServers().Find(ctx, bson.M{
+		"$or": bson.A{
+			bson.M{"state": bson.M{"$in": bson.A{ServerStateDraining, ServerStateDead}}},
+			bson.M{"last_seen": bson.M{"$lt": time.Now().UTC().Add(-serverHeartbeatTimeout)}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var servers []Server
+	if err := c.All(ctx, &servers); err != nil {
+		return nil, err
+	}
+	excluded := make([]string, 0, len(servers))
+	for _, server := range servers {
+		excluded = append(excluded, server.Domain)
+	}
+	return excluded, nil
+}
+
+// staticActiveServers returns the active and healthy servers which sent a
+// heartbeat recently sorted by domain.
+func (p *Promoter) staticActiveServers(ctx context.Context) ([]Server, error) {
+	c, err := p.staticColServers().Find(ctx, bson.M{
+		"last_seen": bson.M{"$gte": time.Now().UTC().Add(-serverHeartbeatTimeout)},
+		"healthy":   true,
+		"state":     bson.M{"$nin": bson.A{ServerStateDraining, ServerStateDead}},
+	}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var servers []Server
+	if err := c.All(ctx, &servers); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// staticUnusedAddressesByServer returns the number of unused addresses of
+// every server which has at least one.
+func (p *Promoter) staticUnusedAddressesByServer(ctx context.Context) (map[string]int64, error) {
+	c, err := p.staticColWatchedAddresses().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filterUnusedAddresses}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$server",
+			"count": bson.M{"$sum": 1},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Server string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := c.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(results))
+	for _, r := range results {
+		counts[r.Server] = r.Count
+	}
+	return counts, nil
+}
+
+// managedPoolCandidates returns the servers whose addresses should be tried in
+// order when assigning an address according to the pool strategy. If no
+// address of these servers is available, any unused address is assigned.
+func (p *Promoter) managedPoolCandidates(ctx context.Context, strategy string) ([]string, error) {
+	switch strategy {
+	case PoolStrategyAny:
+		return nil, nil
+	case PoolStrategyLocal:
+		return []string{p.staticServerDomain}, nil
+	case PoolStrategyRoundRobin:
+		servers, err := p.staticActiveServers(ctx)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to fetch active servers")
+		}
+		if len(servers) == 0 {
+			return nil, nil
+		}
+		p.mu.Lock()
+		start := p.poolRoundRobin % uint64(len(servers))
+		p.poolRoundRobin++
+		p.mu.Unlock()
+		candidates := make([]string, 0, len(servers))
+		for i := range servers {
+			candidates = append(candidates, servers[(start+uint64(i))%uint64(len(servers))].Domain)
+		}
+		return candidates, nil
+	case PoolStrategyWeighted:
+		servers, err := p.staticActiveServers(ctx)
+		if err != nil {
+			return nil, errors.AddContext(err, "failed to fetch active servers")
+		}
+		weights := make(map[string]int64, len(servers))
+		for _, server := range servers {
+			if server.HealthScore > 0 {
+				weights[server.Domain] = server.HealthScore
+			}
+		}
+		return weightedOrder(weights), nil
+	}
+	return nil, fmt.Errorf("unknown pool strategy '%v'", strategy)
+}
+
+// weightedOrder returns the keys of the map in a random order. The chance of a
+// key coming before another one is proportional to its weight.
+func weightedOrder(weights map[string]int64) []string {
+	// Sort the keys for the result to only depend on the randomness.
+	keys := make([]string, 0, len(weights))
+	var total int64
+	for key, weight := range weights {
+		keys = append(keys, key)
+		total += weight
+	}
+	sort.Strings(keys)
+
+	order := make([]string, 0, len(keys))
+	for len(keys) > 0 {
+		r := fastrand.Uint64n(uint64(total))
+		i := 0
+		for ; i < len(keys)-1; i++ {
+			if r < uint64(weights[keys[i]]) {
+				break
+			}
+			r -= uint64(weights[keys[i]])
+		}
+		order = append(order, keys[i])
+		total -= weights[keys[i]]
+		keys = append(keys[:i], keys[i+1:]...)
+	}
+	return order
+}
@@ -0,0 +1,286 @@
+package promoter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gitlab.com/SkynetLabs/skyd/build"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.sia.tech/siad/types"
+)
+
+// TestWeightedOrder is a unit test for weightedOrder.
+func TestWeightedOrder(t *testing.T) {
+	t.Parallel()
+
+	// Empty map.
+	if order := weightedOrder(nil); len(order) != 0 {
+		t.Fatal("expected empty order", order)
+	}
+
+	// Every key should be returned exactly once and heavier keys should
+	// come first more often.
+	weights := map[string]int64{"a": 1, "b": 9}
+	var aFirst int
+	for i := 0; i < 1000; i++ {
+		order := weightedOrder(weights)
+		if len(order) != 2 || order[0] == order[1] {
+			t.Fatal("wrong order", order)
+		}
+		if order[0] == "a" {
+			aFirst++
+		}
+	}
+	if aFirst == 0 || aFirst > 300 {
+		t.Fatal("unexpected distribution", aFirst)
+	}
+}
+
+// TestHealthScore is a unit test for healthScore.
+func TestHealthScore(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ready    bool
+		failures uint64
+		score    int64
+	}{
+		{false, 0, 0},
+		{true, 0, maxHealthScore},
+		{true, 1, maxHealthScore / 2},
+		{true, 3, maxHealthScore / 4},
+		{true, 1000, 1},
+	}
+	for _, test := range tests {
+		if score := healthScore(test.ready, test.failures); score != test.score {
+			t.Fatal("wrong score", test.ready, test.failures, score, test.score)
+		}
+	}
+}
+
+// TestPoolStrategies tests assigning addresses with the different pool
+// strategies.
+func TestPoolStrategies(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	// Create 2 promoters sharing a database. The first one prefers its own
+	// addresses and the second one cycles through the servers.
+	opts1 := DefaultOptions()
+	opts1.PoolStrategy = PoolStrategyLocal
+	p1, node1, err := newTestPromoterWithOptions(t.Name()+"1", t.Name(), "", opts1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts2 := DefaultOptions()
+	opts2.PoolStrategy = PoolStrategyRoundRobin
+	p2, node2, err := newTestPromoterWithOptions(t.Name()+"2", t.Name(), "", opts2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, node := range []interface{ Close() error }{node1, node2, p1, p2} {
+			if err := node.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	ctx := context.Background()
+
+	// Both should show up as active servers with a health score.
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		servers, err := p1.staticActiveServers(ctx)
+		if err != nil {
+			return err
+		}
+		if len(servers) != 2 {
+			return fmt.Errorf("expected 2 active servers but got %v", len(servers))
+		}
+		for _, server := range servers {
+			if server.HealthScore == 0 {
+				return fmt.Errorf("server %v has no health score", server.Domain)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Add some addresses for both servers. The ones of the second server
+	// are older.
+	var addrs []interface{}
+	for i := 0; i < 4; i++ {
+		var addr1, addr2 types.UnlockHash
+		addr1[0], addr1[1] = 1, byte(i)
+		addr2[0], addr2[1] = 2, byte(i)
+		wa2 := p2.newUnusedWatchedAddress(addr2)
+		wa2.CreatedAt = wa2.CreatedAt.Add(-time.Hour)
+		addrs = append(addrs, p1.newUnusedWatchedAddress(addr1), wa2)
+	}
+	if _, err := p1.staticColWatchedAddresses().InsertMany(ctx, addrs); err != nil {
+		t.Fatal(err)
+	}
+	serverOf := func(p *Promoter, user string) string {
+		t.Helper()
+		addr, err := p.AddressForUser(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wa WatchedAddress
+		if err := p.staticColWatchedAddresses().FindOne(ctx, bson.M{"_id": addr}).Decode(&wa); err != nil {
+			t.Fatal(err)
+		}
+		return wa.Server
+	}
+
+	// Without a strategy, there are no candidates and the oldest address
+	// is assigned.
+	candidates, err := p1.managedPoolCandidates(ctx, PoolStrategyAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 0 {
+		t.Fatal("expected no candidates", candidates)
+	}
+
+	// With the local strategy, the server's own addresses are preferred.
+	if server := serverOf(p1, "local"); server != p1.staticServerDomain {
+		t.Fatal("wrong server", server)
+	}
+
+	// Round-robin alternates between servers.
+	server1 := serverOf(p2, "roundrobin1")
+	server2 := serverOf(p2, "roundrobin2")
+	if server1 == server2 {
+		t.Fatal("round-robin should alternate servers", server1, server2)
+	}
+
+	// The weighted strategy considers all healthy servers.
+	candidates, err = p1.managedPoolCandidates(ctx, PoolStrategyWeighted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 || candidates[0] == candidates[1] {
+		t.Fatal("wrong candidates", candidates)
+	}
+}
+
+// TestServerStates tests that addresses of draining and dead servers are not
+// assigned.
+func TestServerStates(t *testing.T) {
+	if testing.Short() {
+		t.SkipNow()
+	}
+	t.Parallel()
+
+	p, node, err := newTestPromoter(t.Name(), t.Name(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx := context.Background()
+
+	// Add an address of this server and older addresses of another server
+	// which is draining.
+	other := "other.server"
+	_, err = p.staticColServers().InsertOne(ctx, Server{
+		Domain:   other,
+		LastSeen: time.Now().UTC(),
+		Healthy:  true,
+		State:    ServerStateDraining,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var addrs []interface{}
+	for i := 0; i < 3; i++ {
+		var addr types.UnlockHash
+		addr[0], addr[1] = 1, byte(i)
+		wa := p.newUnusedWatchedAddress(addr)
+		if i > 0 {
+			wa.Server = other
+			wa.CreatedAt = wa.CreatedAt.Add(-time.Hour)
+		}
+		addrs = append(addrs, wa)
+	}
+	if _, err := p.staticColWatchedAddresses().InsertMany(ctx, addrs); err != nil {
+		t.Fatal(err)
+	}
+	serverOf := func(user string) string {
+		t.Helper()
+		addr, err := p.AddressForUser(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wa WatchedAddress
+		if err := p.staticColWatchedAddresses().FindOne(ctx, bson.M{"_id": addr}).Decode(&wa); err != nil {
+			t.Fatal(err)
+		}
+		return wa.Server
+	}
+
+	// The draining server's addresses are skipped.
+	if server := serverOf("user1"); server != p.staticServerDomain {
+		t.Fatal("wrong server", server)
+	}
+
+	// Once the server is active again, its addresses are assigned.
+	if err := p.SetServerState(ctx, other, ServerStateActive); err != nil {
+		t.Fatal(err)
+	}
+	if server := serverOf("user2"); server != other {
+		t.Fatal("wrong server", server)
+	}
+
+	// Marking the server dead sets its state.
+	if err := p.SetServerState(ctx, other, ServerStateDead); err != nil {
+		t.Fatal(err)
+	}
+	servers, err := p.Servers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := make(map[string]string)
+	for _, s := range servers {
+		states[s.Domain] = s.State
+	}
+	if states[other] != ServerStateDead {
+		t.Fatal("wrong state", states[other])
+	}
+
+	// A draining server doesn't generate new addresses.
+	if err := p.SetServerState(ctx, p.staticServerDomain, ServerStateDraining); err != nil {
+		t.Fatal(err)
+	}
+	before, err := p.staticColWatchedAddresses().CountDocuments(ctx, filterUnusedAddressesOf(p.staticServerDomain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.managedRegenerateAddresses(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := p.staticColWatchedAddresses().CountDocuments(ctx, filterUnusedAddressesOf(p.staticServerDomain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != after {
+		t.Fatal("draining server shouldn't generate addresses", before, after)
+	}
+
+	// Invalid states are rejected.
+	if err := p.SetServerState(ctx, other, "foo"); err == nil {
+		t.Fatal("invalid state should be rejected")
+	}
+}
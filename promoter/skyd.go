@@ -29,7 +29,7 @@ func (p *Promoter) managedProcessAddressUpdate(unused bool, updates ...WatchedAd
 	var additions, removals []types.UnlockHash
 	for _, update := range uniqueUpdates {
 		switch update.OperationType {
-		case operationTypeInsert:
+		case operationTypeInsert, operationTypeReplace:
 			additions = append(additions, update.Address)
 		case operationTypeDelete:
 			removals = append(removals, update.Address)
@@ -44,7 +44,16 @@ func (p *Promoter) managedProcessAddressUpdate(unused bool, updates ...WatchedAd
 	if err := p.staticSkyd.WalletWatchRemovePost(removals, true); err != nil {
 		return errors.AddContext(err, "failed to remove addresses from skyd")
 	}
-	if err := p.staticSkyd.WalletWatchAddPost(additions, unused); err != nil {
+	p.managedDequeueRescan(removals)
+	// Used additions are only queued. They are added to skyd together
+	// with the other queued addresses and a single rescan. Until then skyd
+	// doesn't watch them, so the initial diff picks them up again if the
+	// promoter stops before the rescan.
+	if !unused {
+		p.managedQueueRescan(additions)
+		return nil
+	}
+	if err := p.staticSkyd.WalletWatchAddPost(additions, true); err != nil {
 		return errors.AddContext(err, "failed to add addresses to skyd")
 	}
 	return nil
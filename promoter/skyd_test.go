@@ -1,10 +1,12 @@
 package promoter
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
 	"gitlab.com/NebulousLabs/fastrand"
+	"go.sia.tech/siad/build"
 	"go.sia.tech/siad/types"
 )
 
@@ -139,6 +141,33 @@ func TestProcessAddressUpdate(t *testing.T) {
 	if len(wg.Addresses) != 0 {
 		t.Fatal("wrong length", len(wg.Addresses))
 	}
+
+	// Used addresses are only added to skyd by the coalesced rescan.
+	updates = []WatchedAddressUpdate{addr1Insert}
+	err = p.managedProcessAddressUpdate(false, updates...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg, err = p.staticSkyd.WalletWatchGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wg.Addresses) != 0 {
+		t.Fatal("used address shouldn't be watched before the rescan", len(wg.Addresses))
+	}
+	err = build.Retry(100, 100*time.Millisecond, func() error {
+		wg, err := p.staticSkyd.WalletWatchGet()
+		if err != nil {
+			return err
+		}
+		if len(wg.Addresses) != 1 || wg.Addresses[0] != addr1 {
+			return fmt.Errorf("expected used address to be watched but got %v", wg.Addresses)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 }
 
 // TestTxnsByAddress is a unit test for staticTxnsByAddress.
@@ -1,9 +1,12 @@
 package test
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
+	"github.com/SkynetLabs/siacoin-promoter/api"
+	"github.com/SkynetLabs/siacoin-promoter/promoter"
 	"github.com/SkynetLabs/siacoin-promoter/utils"
 	"gitlab.com/SkynetLabs/skyd/build"
 	"go.sia.tech/siad/types"
@@ -94,12 +97,43 @@ func TestDeadServer(t *testing.T) {
 		return err
 	})
 
+	// Marking the server dead without admin credentials should fail.
+	unauthenticated := api.NewClient(fmt.Sprintf("http://%s", tester.staticAPI.Address()))
+	if err := unauthenticated.MarkServerDead(t.Name()); err == nil {
+		t.Fatal("unauthenticated request should fail")
+	}
+
 	// Mark the server dead.
 	err = tester.MarkServerDead(t.Name())
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	// The audit log should contain the assignment and marking the server
+	// dead.
+	entries, err := tester.AuditLog(promoter.AuditFilter{Server: t.Name()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("wrong number of audit entries", len(entries))
+	}
+	if entries[0].Action != promoter.AuditActionMarkServerDead || entries[0].Actor != "token" {
+		t.Fatal("wrong audit entry", entries[0])
+	}
+	if entries[1].Action != promoter.AuditActionAssignAddress || entries[1].Actor != "user:foo-bar" {
+		t.Fatal("wrong audit entry", entries[1])
+	}
+
+	// Marking the server dead doesn't change its state.
+	servers, err := tester.Servers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 1 || servers[0].Domain != t.Name() || servers[0].State != promoter.ServerStateActive {
+		t.Fatal("wrong servers", servers)
+	}
+
 	// Fetch another address. Shouldn't be the same since the old one
 	// belonged to this server and was marked as !primary.
 	// We do this in a loop since the pool of addresses was cleared in will
@@ -43,6 +43,23 @@ func TestSkydConnection(t *testing.T) {
 	if !hg.SkydAlive {
 		t.Fatal("skyd isn't alive")
 	}
+	if !hg.WalletMatch {
+		t.Fatal("wallet doesn't match")
+	}
+
+	// The errors of the background loops are only available to admins.
+	for name, bs := range hg.Backoffs {
+		if bs.LastError != "" {
+			t.Fatal("health shouldn't expose errors", name, bs.LastError)
+		}
+	}
+	backoffs, err := tester.Backoffs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backoffs) != len(hg.Backoffs) {
+		t.Fatal("wrong number of backoffs", len(backoffs), len(hg.Backoffs))
+	}
 }
 
 // TestAddressEndpoint makes sure the address endpoint returns an address for a
@@ -17,6 +17,10 @@ import (
 	"gitlab.com/SkynetLabs/skyd/node/api/client"
 )
 
+// testAdminToken is the admin token used by testers.
+// nolint:gosec // Disable gosec since this is only a test credential.
+const testAdminToken = "aB3dE6gH9jK2mN5p"
+
 // newTestPromoter creates a Promoter instance for testing.
 func newTestPromoter(skyd *client.Client, name, accountsAddr string) (*promoter.Promoter, error) {
 	username := "admin"
@@ -26,7 +30,7 @@ func newTestPromoter(skyd *client.Client, name, accountsAddr string) (*promoter.
 	logger := logrus.New()
 	logger.SetOutput(io.Discard)
 	ac := promoter.NewAccountsClient(accountsAddr)
-	return promoter.New(context.Background(), dependencies.ProdDependencies, ac, skyd, logrus.NewEntry(logger), uri, username, password, name, name)
+	return promoter.New(context.Background(), dependencies.ProdDependencies, ac, skyd, logrus.NewEntry(logger), uri, username, password, name, name, promoter.DefaultOptions())
 }
 
 // Tester is a pair of an API and a client to talk to that API for testing.
@@ -101,14 +105,16 @@ func newTester(skydClient *client.Client, server string) (*Tester, error) {
 	}
 
 	// Create API.
-	a, err := api.New(logrus.NewEntry(logger), db, 0)
+	opts := api.DefaultOptions()
+	opts.AdminToken = testAdminToken
+	a, err := api.New(logrus.NewEntry(logger), db, 0, opts)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create client pointing to API.
 	addr := fmt.Sprintf("http://%s", a.Address())
-	client := api.NewClient(addr)
+	client := api.NewAdminClient(addr, testAdminToken)
 	tester := &Tester{
 		PromoterClient:    client,
 		staticAccountsSrv: accountsSrv,